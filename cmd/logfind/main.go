@@ -23,33 +23,64 @@
 package main
 
 import (
+	"github.com/Asmodai/gotools/internal/config"
+	"github.com/Asmodai/gotools/internal/entity"
 	"github.com/Asmodai/gotools/internal/memfile"
 	"github.com/Asmodai/gotools/internal/search"
 
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+const (
+	// Width of diffs when the terminal width is not known.
+	DIFF_WIDTH int = 120
+)
+
 type LogFind struct {
 	Code string
 
 	Options struct {
 		Debug      bool
 		File       string
+		Config     string
+		Color      string
+		Theme      string
+		Format     string
+		Schema     string
+		Source     string
+		Context    int
 		Count      bool
+		Group      bool
+		Depth      int
+		Export     string
+		Columns    string
+		Redact     bool
+		TimeZone   string
+		TimeFormat string
+		MinLevel   string
+		Diff       string
+		Follow     bool
 		DumpTokens bool
 		DumpSyntax bool
 		DumpProg   bool
 	}
 
-	mfile   *memfile.MemFile
+	config  *config.Config
+	format  entity.Format
+	schema  *entity.Schema
+	mfile   memfile.LineSource
 	parser  *search.Parser
 	vm      *search.VM
 	flags   *flag.FlagSet
 	program *search.Optimiser
+	minimum entity.Severity
 }
 
 func (lf *LogFind) Usage() {
@@ -57,7 +88,7 @@ func (lf *LogFind) Usage() {
 
 	fmt.Fprintf(
 		flag.CommandLine.Output(),
-		"Usage of %s:\n%s [-debug <bool>] [-file <string>] <term...>\n",
+		"Usage of %s:\n%s [-debug <bool>] [-file <string>] [-color <string>] [-theme <string>] [-format <string>] [-schema <string>] <term...>\n",
 		name,
 		name,
 	)
@@ -100,14 +131,265 @@ func (lf *LogFind) findTerm() {
 	lf.Code = strings.Join(lf.flags.Args(), " ")
 }
 
+// Without a file, the log is read from the standard input if it is not
+// a terminal.
 func (lf *LogFind) validate() {
-	if lf.Options.File == "" {
+	if lf.Options.File == "" && !memfile.Piped(os.Stdin) {
 		lf.Log("Fatal: No log file provided!")
 		lf.Usage()
 		os.Exit(2)
 	}
 }
 
+// Was the flag given on the command line?
+func (lf *LogFind) flagGiven(name string) bool {
+	given := false
+
+	lf.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+
+	return given
+}
+
+func (lf *LogFind) loadConfig() {
+	cfg, err := config.Load(lf.Options.Config)
+	if err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	if err := cfg.Apply(); err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	if err := cfg.ApplyTime(lf.Options.TimeZone, lf.Options.TimeFormat); err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	if lf.flagGiven("redact") {
+		if err := cfg.ApplyRedaction(lf.Options.Redact); err != nil {
+			lf.Log("Fatal: " + err.Error())
+			os.Exit(2)
+		}
+	}
+
+	lf.config = cfg
+
+	// Aliases from the configuration may name the minimum level.
+	min, err := entity.ParseMinLevel(lf.Options.MinLevel)
+	if err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+	lf.minimum = min
+}
+
+func (lf *LogFind) loadRenderer() {
+	name := lf.Options.Theme
+	if name == "" {
+		name = lf.config.Theme
+	}
+
+	theme, err := entity.FindTheme(name)
+	if err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	rnd, err := entity.RendererForMode(lf.Options.Color, os.Stdout, theme)
+	if err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	entity.SetRenderer(rnd)
+}
+
+// Source roots given on the command line are searched before those in
+// the configuration.
+func (lf *LogFind) loadSources() {
+	roots := []entity.SourceRoot{}
+
+	if lf.Options.Source != "" {
+		for _, spec := range filepath.SplitList(lf.Options.Source) {
+			roots = append(roots, entity.ParseSourceRoot(spec))
+		}
+	}
+
+	entity.SetSourceRoots(append(roots, lf.config.SourceRoots...))
+
+	if lf.Options.Context >= 0 {
+		entity.SetSourceContext(lf.Options.Context)
+	}
+}
+
+// Open the log.  A log read from a pipe is searched once all of it has
+// been read or, when following it, once there is enough to detect its
+// format or it has been waited on for long enough.
+func (lf *LogFind) open() {
+	source, err := memfile.OpenSource(lf.Options.File)
+	if err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+	lf.mfile = source
+
+	if mf, ok := source.(*memfile.MemFile); ok && lf.Options.Debug {
+		if path, size := mf.Sidecar(); path != "" {
+			lf.Logf("Reading %s log through index '%s' of %d bytes.\n", mf.Compression(), path, size)
+		}
+	}
+
+	if spool, ok := source.(*memfile.Spool); ok {
+		if lf.Options.Follow {
+			err = spool.WaitLines(entity.FORMAT_SAMPLE_SIZE, memfile.SPOOL_WAIT)
+		} else {
+			err = spool.Wait()
+		}
+
+		if err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+	}
+}
+
+func (lf *LogFind) loadFormat() {
+	var format entity.Format
+	var schema *entity.Schema
+	var err error
+
+	head, err := lf.mfile.Head(entity.FORMAT_SAMPLE_SIZE)
+	if err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+
+	if lf.Options.Format == entity.FORMAT_AUTO {
+		format = entity.DetectFormat(head)
+	} else {
+		format, err = entity.FindFormat(lf.Options.Format)
+		if err != nil {
+			lf.Log("Fatal: " + err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if lf.Options.Schema == entity.SCHEMA_AUTO {
+		schema = entity.DetectSchema(head, format)
+	} else {
+		schema, err = entity.FindSchema(lf.Options.Schema)
+		if err != nil {
+			lf.Log("Fatal: " + err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if lf.Options.Debug {
+		lf.Logf("Using format '%s' with schema '%s'.\n", format.Name(), schema.Name)
+	}
+
+	lf.format = format
+	lf.schema = schema
+	lf.vm.SetFormat(format)
+	lf.vm.SetSchema(schema)
+}
+
+func (lf *LogFind) printLine(num int, buf string) {
+	rnd := entity.GetRenderer()
+
+	fmt.Printf(
+		"%s %s\n",
+		rnd.Style(entity.STYLE_KEY, fmt.Sprintf("%d:", num)),
+		rnd.Style(entity.STYLE_PLAIN, lf.redact(buf)),
+	)
+}
+
+// Search lines as they are written to the log, like `tail -f | grep`.
+//
+// A pipe is searched from its start.
+func (lf *LogFind) runFollow() {
+	var lines int
+
+	if _, ok := lf.mfile.(*memfile.Spool); !ok {
+		<-lf.mfile.Indexed()
+
+		count, err := lf.mfile.Lines()
+		if err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+		lines = count
+	}
+
+	follower, err := lf.mfile.Follow(memfile.FOLLOW_INTERVAL)
+	if err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+	defer follower.Close()
+
+	for range follower.Changed() {
+		added, err := lf.mfile.Refresh()
+		if err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+
+		bufs, err := lf.mfile.ReadRange(added)
+		if err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+
+		// Lines in a rotated file are numbered from its start.
+		lines = lf.followLines(lines, bufs[:added.Drained])
+		if added.Rotated {
+			lines = 0
+		}
+		lines = lf.followLines(lines, bufs[added.Drained:])
+	}
+}
+
+// Search lines following the given line number, returning the number
+// of the last.
+func (lf *LogFind) followLines(num int, bufs []string) int {
+	for _, buf := range bufs {
+		num++
+
+		if err := lf.vm.SetBuffer(buf); err != nil {
+			continue
+		}
+
+		lf.vm.Run()
+		if lf.vm.Result() == 1 && lf.vm.Severity().Passes(lf.minimum) {
+			lf.printLine(num, buf)
+		}
+	}
+
+	return num
+}
+
+// Redact a matching line, keeping its format.
+func (lf *LogFind) redact(buf string) string {
+	red := entity.GetRedactor()
+	if red == nil {
+		return buf
+	}
+
+	line, err := lf.format.Decode(buf)
+	if err != nil {
+		return red.RedactText(buf)
+	}
+
+	return red.RedactRaw(buf, line, lf.schema)
+}
+
 func (lf *LogFind) optional() {
 	if lf.Options.DumpTokens {
 		lf.parser.PrintTokens()
@@ -127,11 +409,70 @@ func (lf *LogFind) optional() {
 
 func (lf *LogFind) Init() {
 	lf.flags.BoolVar(&lf.Options.Debug, "debug", false, "Debug mode.")
-	lf.flags.StringVar(&lf.Options.File, "file", "", "Log file to parse.")
+	lf.flags.StringVar(&lf.Options.File, "file", "", "Log file to parse, or '-' for the standard input.")
 	lf.flags.BoolVar(&lf.Options.Count, "count", false, "Show only number of matches.")
+	lf.flags.BoolVar(&lf.Options.Group, "group", false, "Group matches by stack trace.")
+	lf.flags.BoolVar(&lf.Options.Follow, "follow", false, "Follow the log, showing new matches as they are written.")
+	lf.flags.IntVar(&lf.Options.Depth, "depth", entity.FINGERPRINT_DEPTH, "Number of frames used to group stack traces.")
+	lf.flags.StringVar(
+		&lf.Options.Export,
+		"export",
+		"",
+		"Export matches, one of: "+strings.Join(entity.ExporterNames(), ", ")+".",
+	)
+	lf.flags.StringVar(
+		&lf.Options.Columns,
+		"columns",
+		strings.Join(entity.DefaultColumns, ","),
+		"Comma-separated fields written by CSV exports.",
+	)
+	lf.flags.StringVar(&lf.Options.Config, "config", config.DefaultPath(), "Configuration file.")
+	lf.flags.StringVar(&lf.Options.Color, "color", entity.COLOR_AUTO, "Colour output, one of: auto, always, never, html.")
+	lf.flags.StringVar(
+		&lf.Options.Theme,
+		"theme",
+		"",
+		"Colour theme, one of: "+strings.Join(entity.ThemeNames(), ", ")+", or one from the configuration.",
+	)
+	lf.flags.StringVar(
+		&lf.Options.Format,
+		"format",
+		entity.FORMAT_AUTO,
+		"Log format, one of: auto, "+strings.Join(entity.FormatNames(), ", ")+".",
+	)
+	lf.flags.StringVar(
+		&lf.Options.Schema,
+		"schema",
+		entity.SCHEMA_AUTO,
+		"Log schema, one of: auto, "+strings.Join(entity.SchemaNames(), ", ")+".",
+	)
+	lf.flags.StringVar(
+		&lf.Options.Source,
+		"source",
+		"",
+		"Source roots for stack traces, as a list of `path` or `prefix=path` separated by '"+string(os.PathListSeparator)+"'.",
+	)
+	lf.flags.IntVar(&lf.Options.Context, "context", -1, "Lines of source shown around stack frames.")
+	lf.flags.StringVar(
+		&lf.Options.MinLevel,
+		"level",
+		"",
+		"Minimum level shown, one of: "+strings.Join(entity.LevelNames(), ", ")+".",
+	)
+	lf.flags.StringVar(&lf.Options.Diff, "diff", "", "Compare the entries on two lines, given as '<line>,<line>'.")
+	lf.flags.StringVar(&lf.Options.TimeZone, "tz", "", "Time zone times are shown in, such as UTC, Local or Europe/London.")
+	lf.flags.StringVar(
+		&lf.Options.TimeFormat,
+		"time-format",
+		"",
+		"Time format, one of: "+strings.Join(entity.TimeFormatNames(), ", ")+", or a Go time layout.",
+	)
+	lf.flags.BoolVar(&lf.Options.Redact, "redact", false, "Redact sensitive values, overriding the configuration.")
 	lf.flags.BoolVar(&lf.Options.Debug, "d", false, "Debug mode.")
-	lf.flags.StringVar(&lf.Options.File, "f", "", "Log file to parse.")
+	lf.flags.StringVar(&lf.Options.File, "f", "", "Log file to parse, or '-' for the standard input.")
 	lf.flags.BoolVar(&lf.Options.Count, "c", false, "Show only number of matches.")
+	lf.flags.BoolVar(&lf.Options.Follow, "F", false, "Follow the log, showing new matches as they are written.")
+	lf.flags.BoolVar(&lf.Options.Group, "g", false, "Group matches by stack trace.")
 	lf.flags.BoolVar(&lf.Options.DumpTokens, "t", false, "Print tokens and exit.")
 	lf.flags.BoolVar(&lf.Options.DumpSyntax, "s", false, "Print syntax and exit.")
 	lf.flags.BoolVar(&lf.Options.DumpProg, "p", false, "Print program and exit.")
@@ -142,6 +483,15 @@ func (lf *LogFind) Init() {
 	}
 
 	lf.validate()
+	lf.loadConfig()
+	lf.loadRenderer()
+	lf.loadSources()
+
+	// Diffs do not search.
+	if lf.Options.Diff != "" {
+		return
+	}
+
 	lf.findTerm()
 	lf.vm.SetDebug(lf.Options.Debug)
 	lf.loadTerm()
@@ -154,18 +504,42 @@ func (lf *LogFind) Run() {
 	var lines int
 	var matched int = 0
 
-	if err := lf.mfile.Open(lf.Options.File); err != nil {
-		lf.Log(err.Error())
-		os.Exit(3)
-	}
+	lf.open()
 	defer lf.mfile.Close()
 
+	lf.loadFormat()
+
+	if lf.Options.Diff != "" {
+		lf.runDiff()
+		return
+	}
+
+	if lf.Options.Export != "" {
+		lf.runExport()
+		return
+	}
+
+	if lf.Options.Follow {
+		lf.runFollow()
+		return
+	}
+
+	if lf.Options.Group {
+		lf.runGroups()
+		return
+	}
+
+	<-lf.mfile.Indexed()
+
 	lines, err := lf.mfile.Lines()
 	if err != nil {
 		lf.Log(status.Error())
 		os.Exit(3)
 	}
 
+	rnd := entity.GetRenderer()
+	fmt.Print(rnd.Begin())
+
 	lf.mfile.GotoEnd()
 	for {
 		buf, status = lf.mfile.ReadPrevLine()
@@ -187,14 +561,19 @@ func (lf *LogFind) Run() {
 		}
 
 		if err := lf.vm.SetBuffer(buf); err != nil {
-			lf.Log(err.Error())
-			os.Exit(3)
+			// Lines that cannot be decoded can never match.
+			if lf.Options.Debug {
+				lf.Logf("Line %d: %s\n", lines, err.Error())
+			}
+
+			lines--
+			continue
 		}
 
 		lf.vm.Run()
-		if lf.vm.Result() == 1 {
+		if lf.vm.Result() == 1 && lf.vm.Severity().Passes(lf.minimum) {
 			if !lf.Options.Count {
-				fmt.Printf("%d: %s\n", lines, buf)
+				lf.printLine(lines, buf)
 			}
 			matched++
 		}
@@ -204,16 +583,193 @@ func (lf *LogFind) Run() {
 
 	switch matched {
 	case 1:
-		fmt.Printf("1 match.\n")
+		fmt.Printf("%s\n", rnd.Style(entity.STYLE_PLAIN, "1 match."))
 	default:
-		fmt.Printf("%d matches.\n", matched)
+		fmt.Printf("%s\n", rnd.Style(entity.STYLE_PLAIN, fmt.Sprintf("%d matches.", matched)))
+	}
+	fmt.Print(rnd.End())
+}
+
+// Search the log from the start, calling `fn` with each matching
+// entity, redacted if need be.  Continuation lines are assembled so that panics written
+// after an entry are included.
+//
+// The log is read a line at a time, so that only the entry being
+// searched is held in memory.
+func (lf *LogFind) eachMatch(fn func(entity.Entity)) {
+	asm := entity.NewAssembler(entity.ContinueUndecodable(lf.format))
+
+	lf.mfile.GotoStart()
+	err := asm.Stream(lf.mfile.ReadNextLine, func(entry entity.Entry) {
+		ent := entity.ParseEntry(entry, lf.format, lf.schema)
+		if err := lf.vm.SetEntity(ent); err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+
+		// Search before redacting, so masked values can still be found.
+		lf.vm.Run()
+		if lf.vm.Result() == 1 && ent.Severity().Passes(lf.minimum) {
+			entity.GetRedactor().Redact(ent)
+			fn(ent)
+		}
+	})
+
+	if err != nil && !errors.Is(err, memfile.EOF) {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+}
+
+// Compare the entries on two lines of the log.
+func (lf *LogFind) runDiff() {
+	var nums [2]int
+
+	parts := strings.Split(lf.Options.Diff, ",")
+	if len(parts) != 2 {
+		lf.Log("Fatal: -diff needs two line numbers, e.g. '12,34'.")
+		os.Exit(2)
+	}
+
+	for idx := range parts {
+		num, err := strconv.Atoi(strings.TrimSpace(parts[idx]))
+		if err != nil || num < 1 {
+			lf.Logf("Fatal: Invalid line number '%s'.\n", parts[idx])
+			os.Exit(2)
+		}
+		nums[idx] = num
+	}
+
+	max := nums[0]
+	if nums[1] > max {
+		max = nums[1]
+	}
+
+	lines, err := lf.mfile.Head(max)
+	if err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+
+	if len(lines) < max {
+		lf.Logf("Fatal: The log has only %d lines.\n", len(lines))
+		os.Exit(2)
+	}
+
+	left := entity.ParseEntries([]entity.Entry{{Head: lines[nums[0]-1]}}, lf.format, lf.schema)[0]
+	right := entity.ParseEntries([]entity.Entry{{Head: lines[nums[1]-1]}}, lf.format, lf.schema)[0]
+
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		width = DIFF_WIDTH
+	}
+
+	rnd := entity.GetRenderer()
+	fmt.Print(rnd.Begin())
+	entity.DisplayDiffHeadedTo(
+		os.Stdout,
+		entity.DiffEntities(left, right),
+		width,
+		fmt.Sprintf("Line %d", nums[0]),
+		fmt.Sprintf("Line %d", nums[1]),
+	)
+	fmt.Print(rnd.End())
+}
+
+func (lf *LogFind) runExport() {
+	columns := []string{}
+	for _, col := range strings.Split(lf.Options.Columns, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, col)
+		}
+	}
+
+	exp, err := entity.NewExporter(lf.Options.Export, lf.schema, columns)
+	if err != nil {
+		lf.Log("Fatal: " + err.Error())
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if err := exp.Begin(out); err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
 	}
+
+	lf.eachMatch(func(ent entity.Entity) {
+		if err := exp.Export(out, ent); err != nil {
+			lf.Log(err.Error())
+			os.Exit(3)
+		}
+	})
+
+	if err := exp.End(out); err != nil {
+		lf.Log(err.Error())
+		os.Exit(3)
+	}
+}
+
+// Group matching entities by their stack trace.
+func (lf *LogFind) runGroups() {
+	grouper := entity.NewTraceGrouper(lf.Options.Depth)
+	matched := 0
+
+	lf.eachMatch(func(ent entity.Entity) {
+		if grouper.Add(ent) {
+			matched++
+		}
+	})
+
+	rnd := entity.GetRenderer()
+	fmt.Print(rnd.Begin())
+
+	groups := grouper.Groups()
+	for idx, group := range groups {
+		if !lf.Options.Count {
+			lf.displayGroup(idx+1, group)
+		}
+	}
+
+	fmt.Printf(
+		"%s\n",
+		rnd.Style(
+			entity.STYLE_PLAIN,
+			fmt.Sprintf("%d traced matches in %d groups.", matched, len(groups)),
+		),
+	)
+	fmt.Print(rnd.End())
+}
+
+func (lf *LogFind) displayGroup(num int, group *entity.TraceGroup) {
+	rnd := entity.GetRenderer()
+	first, last := "unknown", "unknown"
+
+	if !group.First.IsZero() {
+		first = entity.FormatTime(group.First)
+		last = entity.FormatTime(group.Last)
+	}
+
+	fmt.Printf(
+		"%s %s\n%s %s\n%s %s\n%s %s\n%s\n",
+		rnd.Style(entity.STYLE_KEY, fmt.Sprintf("Group %d:", num)),
+		rnd.Style(entity.STYLE_PLAIN, group.Fingerprint),
+		rnd.Style(entity.STYLE_KEY, "Count:"),
+		rnd.Style(entity.STYLE_PLAIN, fmt.Sprintf("%d", group.Count)),
+		rnd.Style(entity.STYLE_KEY, "First seen:"),
+		rnd.Style(entity.STYLE_TIMESTAMP, first),
+		rnd.Style(entity.STYLE_KEY, "Last seen:"),
+		rnd.Style(entity.STYLE_TIMESTAMP, last),
+		rnd.Style(entity.STYLE_PLAIN, group.Entity.Short(80)),
+	)
+	group.Trace.Display()
+	fmt.Printf("\n")
 }
 
 func NewLogFind() *LogFind {
 	return &LogFind{
 		flags:  flag.NewFlagSet(os.Args[0], flag.ExitOnError),
-		mfile:  memfile.NewMemFile(),
 		parser: search.NewParser(),
 		vm:     search.NewVM(),
 	}
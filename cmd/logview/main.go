@@ -23,17 +23,21 @@
 package main
 
 import (
+	"github.com/Asmodai/gotools/internal/config"
 	"github.com/Asmodai/gotools/internal/entity"
 	"github.com/Asmodai/gotools/internal/memfile"
 //	"github.com/Asmodai/gotools/internal/search"
 
 	"github.com/awesome-gocui/gocui"
 
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
@@ -44,6 +48,30 @@ const (
 	IcoBoth        string = "Up Dn"
 )
 
+// Map a theme colour name to a gocui colour.
+func colorAttribute(name string) gocui.Attribute {
+	switch strings.ToLower(name) {
+	case "black":
+		return gocui.ColorBlack
+	case "red":
+		return gocui.ColorRed
+	case "green":
+		return gocui.ColorGreen
+	case "yellow":
+		return gocui.ColorYellow
+	case "blue":
+		return gocui.ColorBlue
+	case "magenta":
+		return gocui.ColorMagenta
+	case "cyan":
+		return gocui.ColorCyan
+	case "white":
+		return gocui.ColorWhite
+	}
+
+	return gocui.ColorDefault
+}
+
 func lineInView(v *gocui.View, dir int) bool {
 	_, y := v.Cursor()
 	line, err := v.Line(y + dir)
@@ -64,19 +92,44 @@ func lineAbove(v *gocui.View) bool {
 type LogViewer struct {
 	ents []entity.Entity
 
-	log   *memfile.MemFile
+	log   memfile.LineSource
 	wnd   *memfile.Window
 	gui   *gocui.Gui
 	//vm    *search.VM
 	flags *flag.FlagSet
 
-	maxX  int
-	maxY  int
-	lines int
+	maxX   int
+	maxY   int
+	lines  int
+	format entity.Format
+	schema *entity.Schema
+	asm    *entity.Assembler
+	config *config.Config
+	theme  *entity.Theme
+	min    entity.Severity
+	marked entity.Entity
+	diff   bool
+	follow *memfile.Follower
 
 	Options struct {
-		Debug bool
-		File  string
+		Debug        bool
+		File         string
+		Config       string
+		Color        string
+		Theme        string
+		Format       string
+		Schema       string
+		Continuation string
+		Source       string
+		Context      int
+		Export       string
+		ExportFile   string
+		Columns      string
+		Redact       bool
+		TimeZone     string
+		TimeFormat   string
+		MinLevel     string
+		Follow       bool
 	}
 
 	logPane struct {
@@ -88,7 +141,6 @@ type LogViewer struct {
 
 func NewLogViewer() *LogViewer {
 	return &LogViewer{
-		log:   memfile.NewMemFile(),
 		flags: flag.NewFlagSet(os.Args[0], flag.ExitOnError),
 	}
 }
@@ -102,28 +154,229 @@ func (lv *LogViewer) Usage() {
 
 	fmt.Fprintf(
 		flag.CommandLine.Output(),
-		"Usage of %s:\n%s [-debug <bool>] [-file <string>] <term...>\n",
+		"Usage of %s:\n%s [-debug <bool>] [-file <string>] [-color <string>] [-theme <string>] [-format <string>] [-schema <string>] <term...>\n",
 		name,
 		name,
 	)
 	lv.flags.PrintDefaults()
 }
 
+// Without a file, the log is read from the standard input if it is not
+// a terminal.
 func (lv *LogViewer) validate() {
-	if lv.Options.File == "" {
+	if lv.Options.File == "" && !memfile.Piped(os.Stdin) {
 		lv.Log("Fatal: No log file provided!")
 		lv.Usage()
 		os.Exit(2)
 	}
 }
 
+func (lv *LogViewer) loadConfig() error {
+	cfg, err := config.Load(lv.Options.Config)
+	if err != nil {
+		return err
+	}
+
+	lv.config = cfg
+
+	if err := cfg.Apply(); err != nil {
+		return err
+	}
+
+	if err := cfg.ApplyTime(lv.Options.TimeZone, lv.Options.TimeFormat); err != nil {
+		return err
+	}
+
+	if lv.flagGiven("redact") {
+		if err := cfg.ApplyRedaction(lv.Options.Redact); err != nil {
+			return err
+		}
+	}
+
+	// Aliases from the configuration may name the minimum level.
+	lv.min, err = entity.ParseMinLevel(lv.Options.MinLevel)
+
+	return err
+}
+
+// Was the flag given on the command line?
+func (lv *LogViewer) flagGiven(name string) bool {
+	given := false
+
+	lv.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+
+	return given
+}
+
+// The viewer always draws to a terminal, so only NO_COLOR and the colour
+// mode decide whether colour is used.
+func (lv *LogViewer) loadRenderer() error {
+	if lv.Options.Color == entity.COLOR_HTML {
+		return fmt.Errorf("Colour mode '%s' is not supported by the viewer", lv.Options.Color)
+	}
+
+	name := lv.Options.Theme
+	if name == "" {
+		name = lv.config.Theme
+	}
+
+	theme, err := entity.FindTheme(name)
+	if err != nil {
+		return err
+	}
+	lv.theme = theme
+
+	rnd, err := entity.RendererForMode(lv.Options.Color, nil, theme)
+	if err != nil {
+		return err
+	}
+
+	entity.SetRenderer(rnd)
+
+	return nil
+}
+
+// Source roots given on the command line are searched before those in
+// the configuration.
+func (lv *LogViewer) loadSources() {
+	roots := []entity.SourceRoot{}
+
+	if lv.Options.Source != "" {
+		for _, spec := range filepath.SplitList(lv.Options.Source) {
+			roots = append(roots, entity.ParseSourceRoot(spec))
+		}
+	}
+
+	entity.SetSourceRoots(append(roots, lv.config.SourceRoots...))
+
+	if lv.Options.Context >= 0 {
+		entity.SetSourceContext(lv.Options.Context)
+	}
+}
+
+// Open the log.  A log read from a pipe is shown once all of it has
+// been read or, when following it, once there is enough to detect its
+// format or it has been waited on for long enough.
+func (lv *LogViewer) open() error {
+	source, err := memfile.OpenSource(lv.Options.File)
+	if err != nil {
+		return err
+	}
+	lv.log = source
+
+	if spool, ok := source.(*memfile.Spool); ok {
+		if lv.Options.Follow {
+			return spool.WaitLines(entity.FORMAT_SAMPLE_SIZE, memfile.SPOOL_WAIT)
+		}
+
+		return spool.Wait()
+	}
+
+	return nil
+}
+
+func (lv *LogViewer) loadFormat() error {
+	var err error
+
+	head, err := lv.log.Head(entity.FORMAT_SAMPLE_SIZE)
+	if err != nil {
+		return err
+	}
+
+	if lv.Options.Format == entity.FORMAT_AUTO {
+		lv.format = entity.DetectFormat(head)
+	} else {
+		if lv.format, err = entity.FindFormat(lv.Options.Format); err != nil {
+			return err
+		}
+	}
+
+	if lv.Options.Schema == entity.SCHEMA_AUTO {
+		lv.schema = entity.DetectSchema(head, lv.format)
+	} else {
+		if lv.schema, err = entity.FindSchema(lv.Options.Schema); err != nil {
+			return err
+		}
+	}
+
+	lv.asm, err = entity.AssemblerFromSpec(lv.Options.Continuation, lv.format)
+
+	return err
+}
+
 func (lv *LogViewer) Init() error {
 	var err error
 
 	lv.flags.BoolVar(&lv.Options.Debug, "debug", false, "Debug mode.")
-	lv.flags.StringVar(&lv.Options.File, "file", "", "Log file to parse.")
+	lv.flags.StringVar(&lv.Options.File, "file", "", "Log file to parse, or '-' for the standard input.")
+	lv.flags.StringVar(&lv.Options.Config, "config", config.DefaultPath(), "Configuration file.")
+	lv.flags.StringVar(&lv.Options.Color, "color", entity.COLOR_AUTO, "Colour output, one of: auto, always, never.")
+	lv.flags.StringVar(
+		&lv.Options.Theme,
+		"theme",
+		"",
+		"Colour theme, one of: "+strings.Join(entity.ThemeNames(), ", ")+", or one from the configuration.",
+	)
+	lv.flags.StringVar(
+		&lv.Options.Format,
+		"format",
+		entity.FORMAT_AUTO,
+		"Log format, one of: auto, "+strings.Join(entity.FormatNames(), ", ")+".",
+	)
+	lv.flags.StringVar(
+		&lv.Options.Schema,
+		"schema",
+		entity.SCHEMA_AUTO,
+		"Log schema, one of: auto, "+strings.Join(entity.SchemaNames(), ", ")+".",
+	)
+	lv.flags.StringVar(
+		&lv.Options.Continuation,
+		"continuation",
+		"auto",
+		"Continuation lines, one of: auto, json, indent, none, or a regular expression.",
+	)
+	lv.flags.StringVar(
+		&lv.Options.Source,
+		"source",
+		"",
+		"Source roots for stack traces, as a list of `path` or `prefix=path` separated by '"+string(os.PathListSeparator)+"'.",
+	)
+	lv.flags.IntVar(&lv.Options.Context, "context", -1, "Lines of source shown around stack frames.")
+	lv.flags.StringVar(
+		&lv.Options.Export,
+		"export",
+		entity.EXPORT_NDJSON,
+		"Format used by the export commands, one of: "+strings.Join(entity.ExporterNames(), ", ")+".",
+	)
+	lv.flags.StringVar(&lv.Options.ExportFile, "export-file", "", "File written by the export commands, default 'logview-export.<format>'.")
+	lv.flags.StringVar(
+		&lv.Options.Columns,
+		"columns",
+		strings.Join(entity.DefaultColumns, ","),
+		"Comma-separated fields written by CSV exports.",
+	)
+	lv.flags.StringVar(
+		&lv.Options.MinLevel,
+		"level",
+		"",
+		"Minimum level shown, one of: "+strings.Join(entity.LevelNames(), ", ")+".",
+	)
+	lv.flags.StringVar(&lv.Options.TimeZone, "tz", "", "Time zone times are shown in, such as UTC, Local or Europe/London.")
+	lv.flags.StringVar(
+		&lv.Options.TimeFormat,
+		"time-format",
+		"",
+		"Time format, one of: "+strings.Join(entity.TimeFormatNames(), ", ")+", or a Go time layout.",
+	)
+	lv.flags.BoolVar(&lv.Options.Redact, "redact", false, "Redact sensitive values, overriding the configuration.")
+	lv.flags.BoolVar(&lv.Options.Follow, "follow", false, "Follow the log, showing new entries as they are written.")
+	lv.flags.BoolVar(&lv.Options.Follow, "F", false, "Follow the log, showing new entries as they are written.")
 	lv.flags.BoolVar(&lv.Options.Debug, "d", false, "Debug mode.")
-	lv.flags.StringVar(&lv.Options.File, "f", "", "Log file to parse.")
+	lv.flags.StringVar(&lv.Options.File, "f", "", "Log file to parse, or '-' for the standard input.")
 
 	if err := lv.flags.Parse(os.Args[1:]); err != nil {
 		return err
@@ -131,7 +384,17 @@ func (lv *LogViewer) Init() error {
 
 	lv.validate()
 
-	if err = lv.log.Open(lv.Options.File); err != nil {
+	if err = lv.loadConfig(); err != nil {
+		return err
+	}
+
+	if err = lv.loadRenderer(); err != nil {
+		return err
+	}
+
+	lv.loadSources()
+
+	if err = lv.open(); err != nil {
 		return err
 	}
 
@@ -140,6 +403,10 @@ func (lv *LogViewer) Init() error {
 		return err
 	}
 
+	if err = lv.loadFormat(); err != nil {
+		return err
+	}
+
 	lv.gui, err = gocui.NewGui(gocui.OutputNormal, true)
 	if err != nil {
 		return err
@@ -152,9 +419,107 @@ func (lv *LogViewer) Init() error {
 		return err
 	}
 
+	go lv.indexing()
+
+	if lv.Options.Follow {
+		lv.follow, err = lv.log.Follow(memfile.FOLLOW_INTERVAL)
+		if err != nil {
+			return err
+		}
+
+		go lv.following()
+	}
+
 	return nil
 }
 
+// Redraw once the log has been indexed.  Until then the line count and
+// position are of the lines found so far.
+func (lv *LogViewer) indexing() {
+	<-lv.log.Indexed()
+
+	lv.gui.Update(func(g *gocui.Gui) error {
+		var err error
+
+		if lv.lines, err = lv.log.Lines(); err != nil {
+			return err
+		}
+
+		if lv.wnd == nil {
+			return nil
+		}
+
+		return lv.redraw(g)
+	})
+}
+
+// Refresh the log whenever the follower says it may have grown.
+func (lv *LogViewer) following() {
+	for range lv.follow.Changed() {
+		lv.gui.Update(lv.grown)
+	}
+}
+
+// Pick up lines added to the log.  The log pane scrolls to show them
+// when it is showing the newest entries, and shows the newest entries
+// of a log that has been rotated.
+func (lv *LogViewer) grown(g *gocui.Gui) error {
+	added, err := lv.log.Refresh()
+	if err != nil {
+		return err
+	}
+
+	if added.Empty() {
+		return nil
+	}
+
+	if lv.lines, err = lv.log.Lines(); err != nil {
+		return err
+	}
+
+	if lv.wnd == nil {
+		return nil
+	}
+
+	// Offsets into a rotated file mean nothing in the new one.
+	if added.Rotated {
+		lv.wnd.Setup()
+	} else {
+		lv.wnd.Grown()
+	}
+
+	return lv.redraw(g)
+}
+
+// Redraw the panes, keeping the cursor where it is.
+func (lv *LogViewer) redraw(g *gocui.Gui) error {
+	v, err := g.View(LogViewName)
+	if err != nil {
+		return err
+	}
+
+	cx, cy := v.Cursor()
+
+	if err := lv.update(g); err != nil {
+		return err
+	}
+
+	return lv.restoreCursor(v, cx, cy)
+}
+
+// Put the cursor back where it was, or on the last entity if filtering
+// has left fewer of them.
+func (lv *LogViewer) restoreCursor(v *gocui.View, cx, cy int) error {
+	if cy >= len(lv.ents) && cy > 0 {
+		cy = 0
+		if len(lv.ents) > 0 {
+			cy = len(lv.ents) - 1
+		}
+	}
+
+	return v.SetCursor(cx, cy)
+}
+
 func (lv *LogViewer) updateLogs(g *gocui.Gui) error {
 	lv.maxX, lv.maxY = lv.gui.Size()
 	lv.logPane.width = lv.maxX - 1
@@ -195,9 +560,16 @@ func (lv *LogViewer) updateLogs(g *gocui.Gui) error {
 		return err
 	}
 
-	lv.ents, err = entity.ParseLog(data)
-	if err != nil {
-		return err
+	lv.ents = lv.filter(entity.ParseEntries(lv.asm.Assemble(data), lv.format, lv.schema))
+
+	// Filtering may leave fewer entities than the cursor is below.
+	if lv.logPane.selected >= len(lv.ents) && lv.logPane.selected > 0 {
+		cx, _ := v.Cursor()
+		if err := lv.restoreCursor(v, cx, lv.logPane.selected); err != nil {
+			return err
+		}
+
+		_, lv.logPane.selected = v.Cursor()
 	}
 
 	for idx := range lv.ents {
@@ -211,6 +583,22 @@ func (lv *LogViewer) updateLogs(g *gocui.Gui) error {
 	return nil
 }
 
+// Drop entities below the minimum level.
+func (lv *LogViewer) filter(ents []entity.Entity) []entity.Entity {
+	if lv.min == entity.SEVERITY_NONE {
+		return ents
+	}
+
+	shown := make([]entity.Entity, 0, len(ents))
+	for idx := range ents {
+		if ents[idx].Severity().Passes(lv.min) {
+			shown = append(shown, ents[idx])
+		}
+	}
+
+	return shown
+}
+
 func (lv *LogViewer) updateDetails(g *gocui.Gui) error {
 	v, e := g.SetCurrentView(DetailViewName)
 	if e != nil {
@@ -223,14 +611,67 @@ func (lv *LogViewer) updateDetails(g *gocui.Gui) error {
 		return nil
 	}
 
-	if lv.logPane.selected > len(lv.ents) {
+	if lv.logPane.selected >= len(lv.ents) {
 		return nil
 	}
+
+	if lv.diff && lv.marked != nil {
+		width, _ := v.Size()
+		entity.DisplayDiffTo(v, entity.DiffEntities(lv.marked, lv.ents[lv.logPane.selected]), width)
+
+		return nil
+	}
+
 	lv.ents[lv.logPane.selected].DisplayTo(v)
 
 	return nil
 }
 
+// Mark the selected entity for comparison.
+func (lv *LogViewer) mark(g *gocui.Gui, v *gocui.View) error {
+	if lv.logPane.selected >= len(lv.ents) {
+		return nil
+	}
+
+	lv.marked = lv.ents[lv.logPane.selected]
+
+	dv, err := g.View(DetailViewName)
+	if err != nil {
+		return err
+	}
+	dv.Title = "Details [Marked]"
+
+	return nil
+}
+
+// Toggle between the details of the selected entity and its diff
+// against the marked one.
+func (lv *LogViewer) toggleDiff(g *gocui.Gui, v *gocui.View) error {
+	if lv.marked == nil {
+		return nil
+	}
+
+	lv.diff = !lv.diff
+
+	dv, err := g.View(DetailViewName)
+	if err != nil {
+		return err
+	}
+
+	dv.Title = "Details"
+	if lv.diff {
+		dv.Title = "Diff [Marked | Selected]"
+	}
+
+	if err := lv.updateDetails(g); err != nil {
+		return err
+	}
+
+	_, err = g.SetCurrentView(LogViewName)
+
+	return err
+}
+
 func (lv *LogViewer) update(g *gocui.Gui) error {
 	if err := lv.updateDetails(g); err != nil {
 		return err
@@ -281,8 +722,8 @@ func (lv *LogViewer) layoutLogs(g *gocui.Gui) error {
 
 		v.Wrap = false
 		v.Highlight = true
-		v.SelBgColor = gocui.ColorBlue
-		v.SelFgColor = gocui.ColorWhite
+		v.SelBgColor = colorAttribute(lv.theme.SelectionBg)
+		v.SelFgColor = colorAttribute(lv.theme.SelectionFg)
 		v.FgColor = gocui.ColorDefault
 
 		if e = lv.updateLogs(g); e != nil {
@@ -348,6 +789,22 @@ func (lv *LogViewer) keybinds() error {
 		return err
 	}
 
+	if err := lv.gui.SetKeybinding(LogViewName, 'm', gocui.ModNone, lv.mark); err != nil {
+		return err
+	}
+
+	if err := lv.gui.SetKeybinding(LogViewName, 'd', gocui.ModNone, lv.toggleDiff); err != nil {
+		return err
+	}
+
+	if err := lv.gui.SetKeybinding(LogViewName, 'x', gocui.ModNone, lv.export(false)); err != nil {
+		return err
+	}
+
+	if err := lv.gui.SetKeybinding(LogViewName, 'X', gocui.ModNone, lv.export(true)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -357,7 +814,15 @@ func (lv *LogViewer) findSelected() {
 		log.Fatal("Failed to get mojo", err)
 	}
 
-	_, cy := v.Cursor()
+	cx, cy := v.Cursor()
+	if cy >= len(lv.ents) && cy > 0 {
+		if err := lv.restoreCursor(v, cx, cy); err != nil {
+			log.Fatal("Failed to move cursor", err)
+		}
+
+		_, cy = v.Cursor()
+	}
+
 	lv.logPane.selected = cy
 }
 
@@ -381,7 +846,7 @@ func (lv *LogViewer) windowMove(dir int) func(g *gocui.Gui, v *gocui.View) error
 			return err
 		}
 
-		if err := v.SetCursor(cx, cy); err != nil {
+		if err := lv.restoreCursor(v, cx, cy); err != nil {
 			return err
 		}
 
@@ -451,7 +916,112 @@ func (lv *LogViewer) cursorMove(dir int) func(g *gocui.Gui, v *gocui.View) error
 	}
 }
 
+// Export the entries on the current page, or the whole log, to the
+// export file.  The outcome is shown in the title of the details view.
+func (lv *LogViewer) export(all bool) func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		status := ""
+
+		count, err := lv.exportEntities(all)
+		if err != nil {
+			status = "Export failed: " + err.Error()
+		} else {
+			status = fmt.Sprintf("Exported %d entries to %s", count, lv.exportFile())
+		}
+
+		dv, err := g.View(DetailViewName)
+		if err != nil {
+			return err
+		}
+		dv.Title = "Details [" + status + "]"
+
+		return nil
+	}
+}
+
+func (lv *LogViewer) exportFile() string {
+	if lv.Options.ExportFile != "" {
+		return lv.Options.ExportFile
+	}
+
+	return "logview-export." + strings.ToLower(lv.Options.Export)
+}
+
+// Export the entities shown, or all of those in the log.  The log is
+// read a line at a time, so that it is not held in memory.
+func (lv *LogViewer) exportEntities(all bool) (int, error) {
+	columns := []string{}
+	for _, col := range strings.Split(lv.Options.Columns, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, col)
+		}
+	}
+
+	exp, err := entity.NewExporter(lv.Options.Export, lv.schema, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Create(lv.exportFile())
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	out := bufio.NewWriter(file)
+
+	if err := exp.Begin(out); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if all {
+		var failed error
+
+		lv.log.GotoStart()
+		err = lv.asm.Stream(lv.log.ReadNextLine, func(entry entity.Entry) {
+			ents := lv.filter(entity.ParseEntries([]entity.Entry{entry}, lv.format, lv.schema))
+
+			for idx := range ents {
+				if failed != nil {
+					return
+				}
+
+				if failed = exp.Export(out, ents[idx]); failed == nil {
+					count++
+				}
+			}
+		})
+
+		if err != nil && !errors.Is(err, memfile.EOF) {
+			return 0, err
+		}
+
+		if failed != nil {
+			return 0, failed
+		}
+	} else {
+		for idx := range lv.ents {
+			if err := exp.Export(out, lv.ents[idx]); err != nil {
+				return 0, err
+			}
+		}
+
+		count = len(lv.ents)
+	}
+
+	if err := exp.End(out); err != nil {
+		return 0, err
+	}
+
+	return count, out.Flush()
+}
+
 func (lv *LogViewer) quit(g *gocui.Gui, v *gocui.View) error {
+	if lv.follow != nil {
+		lv.follow.Close()
+	}
+
 	v.Clear()
 	g.Close()
 	lv.log.Close()
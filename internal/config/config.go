@@ -0,0 +1,190 @@
+/*
+ * config.go --- Configuration file.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"github.com/Asmodai/gotools/internal/entity"
+	"github.com/Asmodai/gotools/internal/memfile"
+
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	CONFIG_DIR  string = "gotools"
+	CONFIG_FILE string = "config.json"
+)
+
+type Config struct {
+	Formats []entity.FormatDefinition `json:"formats"`
+	Theme   string                    `json:"theme"`
+	Themes  []*entity.Theme           `json:"themes"`
+
+	// Level names mapped to canonical levels, e.g. "verbose": "trace".
+	LevelAliases map[string]string `json:"level_aliases"`
+
+	TimeZone   string `json:"timezone"`
+	TimeFormat string `json:"time_format"`
+
+	SourceRoots   []entity.SourceRoot `json:"source_roots"`
+	SourceContext int                 `json:"source_context"`
+
+	// Redact sensitive values unless told otherwise.
+	Redact      bool                `json:"redact"`
+	RedactRules []entity.RedactRule `json:"redact_rules"`
+
+	// Keep indexes of large and compressed logs between runs, off by
+	// default.  The index of a compressed log holds a recompressed copy
+	// of the whole log, so the cache is limited to the given number of
+	// megabytes.
+	IndexCache      bool  `json:"index_cache"`
+	IndexCacheLimit int64 `json:"index_cache_limit"`
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Formats: []entity.FormatDefinition{},
+		Theme:   entity.THEME_DEFAULT,
+		Themes:  []*entity.Theme{},
+
+		LevelAliases: map[string]string{},
+
+		SourceRoots:   []entity.SourceRoot{},
+		SourceContext: entity.SOURCE_CONTEXT_DEFAULT,
+
+		RedactRules: []entity.RedactRule{},
+
+		IndexCache:      false,
+		IndexCacheLimit: memfile.INDEX_CACHE_LIMIT / (1024 * 1024),
+	}
+}
+
+// Return the default location of the configuration file, or an empty
+// string if there is no user configuration directory.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, CONFIG_DIR, CONFIG_FILE)
+}
+
+// Load the configuration from the given file.
+//
+// A missing file is not an error when it is the default path, in which
+// case an empty configuration is returned.
+func Load(path string) (*Config, error) {
+	cfg := NewConfig()
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && path == DefaultPath() {
+			return cfg, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Apply the configuration to the packages that use it.
+func (c *Config) Apply() error {
+	if err := entity.RegisterFormatDefinitions(c.Formats); err != nil {
+		return err
+	}
+
+	for idx := range c.Themes {
+		if err := entity.RegisterTheme(c.Themes[idx]); err != nil {
+			return err
+		}
+	}
+
+	for alias, level := range c.LevelAliases {
+		if err := entity.RegisterLevelAlias(alias, level); err != nil {
+			return err
+		}
+	}
+
+	if err := entity.SetTimeZone(c.TimeZone); err != nil {
+		return err
+	}
+
+	if err := entity.SetTimeFormat(c.TimeFormat); err != nil {
+		return err
+	}
+
+	entity.SetSourceRoots(c.SourceRoots)
+	entity.SetSourceContext(c.SourceContext)
+
+	memfile.SetIndexCache(c.IndexCache)
+	memfile.SetIndexCacheLimit(c.IndexCacheLimit * 1024 * 1024)
+
+	return c.ApplyRedaction(c.Redact)
+}
+
+// Set the display time zone and format, leaving the configured ones
+// where the given ones are empty.
+func (c *Config) ApplyTime(zone, format string) error {
+	if zone != "" {
+		if err := entity.SetTimeZone(zone); err != nil {
+			return err
+		}
+	}
+
+	if format != "" {
+		return entity.SetTimeFormat(format)
+	}
+
+	return nil
+}
+
+// Enable or disable redaction using the configured rules.
+func (c *Config) ApplyRedaction(enable bool) error {
+	red, err := entity.NewRedactor(c.RedactRules)
+	if err != nil {
+		return err
+	}
+
+	if !enable {
+		red = nil
+	}
+
+	entity.SetRedactor(red)
+
+	return nil
+}
+
+/* config.go ends here. */
@@ -0,0 +1,217 @@
+/*
+ * assembler.go --- Multi-line entry assembly.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Unindented lines of Go, Java and Python stack traces, such as
+	// `panic: ...`, `goroutine 1 [running]:`, `main.main()` or
+	// `Caused by: ...`.
+	traceLineRx = regexp.MustCompile(
+		`^(?:panic: |fatal error: |goroutine \d+ \[|created by |` +
+			`Traceback \(most recent call last\):|Caused by: |` +
+			`Exception in thread |\.\.\. \d+ more$|` +
+			`[\w.$]+(?:Error|Exception)(?::|$)|[\w./*()$-]+\(.*\)$)`,
+	)
+)
+
+// A rule that decides whether a line continues the previous entry.
+type ContinuationRule func(string) bool
+
+// Lines that do not look like the start of a JSON object.
+func ContinueNotJSON(line string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(line), "{")
+}
+
+// Lines that cannot be decoded with the given format and look like
+// part of a stack trace.  Other lines that cannot be decoded, such as
+// truncated JSON, are entries of their own.
+func ContinueUndecodable(format Format) ContinuationRule {
+	return func(line string) bool {
+		text := strings.TrimSpace(line)
+		if strings.HasPrefix(text, "{") {
+			return false
+		}
+
+		if text != "" && !ContinueTraceLike(line) {
+			return false
+		}
+
+		_, err := format.Decode(line)
+
+		return err != nil
+	}
+}
+
+// Lines that are indented or look like part of a stack trace.
+func ContinueTraceLike(line string) bool {
+	return ContinueIndented(line) || traceLineRx.MatchString(line)
+}
+
+// Lines that begin with whitespace.
+func ContinueIndented(line string) bool {
+	if len(line) == 0 {
+		return false
+	}
+
+	return unicode.IsSpace(rune(line[0]))
+}
+
+// Lines that match the given regular expression.
+func ContinueRegexp(re *regexp.Regexp) ContinuationRule {
+	return func(line string) bool {
+		return re.MatchString(line)
+	}
+}
+
+// An entry is a line that begins a log entity along with any
+// continuation lines that follow it.
+type Entry struct {
+	Head     string
+	Attached []string
+}
+
+type Assembler struct {
+	rules []ContinuationRule
+}
+
+// Create an assembler.  A line is a continuation if any of the given
+// rules match it.  With no rules, every line is an entry of its own.
+func NewAssembler(rules ...ContinuationRule) *Assembler {
+	return &Assembler{
+		rules: rules,
+	}
+}
+
+func DefaultAssembler() *Assembler {
+	return NewAssembler(ContinueNotJSON)
+}
+
+// Create an assembler from a textual specification, as given on the
+// command line.
+//
+// The specification is one of:
+//
+//   - "auto", the default: lines that cannot be decoded with the given
+//     format and look like part of a stack trace are continuations.
+//   - "json": lines that do not start a JSON object are continuations.
+//   - "indent": indented lines are continuations.
+//   - "none": no lines are continuations.
+//
+// Anything else is a regular expression matching continuation lines.
+func AssemblerFromSpec(spec string, format Format) (*Assembler, error) {
+	switch spec {
+	case "", "auto":
+		return NewAssembler(ContinueUndecodable(format)), nil
+
+	case "json":
+		return DefaultAssembler(), nil
+
+	case "indent":
+		return NewAssembler(ContinueIndented), nil
+
+	case "none":
+		return NewAssembler(), nil
+	}
+
+	re, err := regexp.Compile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid continuation pattern: %s", err.Error())
+	}
+
+	return NewAssembler(ContinueRegexp(re)), nil
+}
+
+func (a *Assembler) AddRule(rule ContinuationRule) {
+	a.rules = append(a.rules, rule)
+}
+
+func (a *Assembler) isContinuation(line string) bool {
+	for idx := range a.rules {
+		if a.rules[idx](line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Group lines into entries.
+//
+// Continuation lines that have no preceding entry, such as those at the
+// very start of the input, become entries in their own right.
+func (a *Assembler) Assemble(lines []string) []Entry {
+	var entries []Entry = []Entry{}
+
+	for idx := range lines {
+		last := len(entries) - 1
+
+		if last >= 0 && a.isContinuation(lines[idx]) {
+			entries[last].Attached = append(entries[last].Attached, lines[idx])
+			continue
+		}
+
+		entries = append(entries, Entry{Head: lines[idx]})
+	}
+
+	return entries
+}
+
+// Group the lines returned by `next` into entries, calling `fn` with
+// each as it is completed.
+//
+// Lines are read until `next` returns an error, which is returned once
+// the last entry has been passed to `fn`.
+func (a *Assembler) Stream(next func() (string, error), fn func(Entry)) error {
+	var entry *Entry
+
+	for {
+		line, err := next()
+		if err != nil {
+			if entry != nil {
+				fn(*entry)
+			}
+
+			return err
+		}
+
+		if entry != nil && a.isContinuation(line) {
+			entry.Attached = append(entry.Attached, line)
+			continue
+		}
+
+		if entry != nil {
+			fn(*entry)
+		}
+
+		entry = &Entry{Head: line}
+	}
+}
+
+/* assembler.go ends here. */
@@ -33,52 +33,41 @@ import (
 	"time"
 )
 
-type Base struct {
-	Level   string
-	TStamp  time.Time
-	Caller  string
-	Message string
-	Rest    Line
-}
-
-func (b *Base) levelColor(text string) string {
-	var esc string
-
-	switch b.Level {
-	case "INFO":
-		esc = "\x1b[1;32m"
-
-	case "DEBUG":
-		esc = "\x1b[1;33m"
-
-	case "WARN":
-		esc = "\x1b[0;31m"
-
-	case "FATAL":
-		esc = "\x1b[1;37;41m"
+const (
+	levelWidth int = 6
+)
 
-	default:
-		esc = "\x1b[0m"
-	}
+type Base struct {
+	level     string
+	TStamp    time.Time
+	TEncoding TimeEncoding
+	TError    error
+	caller    Caller
+	message   string
+	Rest      Line
+	Attached  []string
 
-	return fmt.Sprintf("%s%s\x1b[0m", esc, text)
+	// Values the logical fields were composed from.
+	original map[string]interface{}
 }
 
 func (b *Base) Short(width int) string {
-	t := b.TStamp.Format(time.RFC1123)
-	w := width - (5 + len(t) + 2)
+	r := GetRenderer()
+	t := FormatTime(b.TStamp)
+	w := width - (levelWidth + len(t) + 2)
 
 	return fmt.Sprintf(
-		"%s \x1b[1;36m%v\x1b[0m %s",
-		b.levelColor(utils.Padable(b.Level).Pad(5)),
-		b.TStamp.Format(time.RFC1123),
-		utils.Elidable(b.Message).Elide(w),
+		"%s %s %s",
+		r.Level(b.level, utils.Padable(b.level).Pad(levelWidth)),
+		r.Style(STYLE_TIMESTAMP, t),
+		r.Style(STYLE_PLAIN, utils.Elidable(b.message).Elide(w)),
 	)
 }
 
 func (b *Base) DisplayTo(w io.Writer) {
 	b.displayHead(w)
 	b.displayRest(w)
+	b.displayAttached(w)
 	fmt.Fprintf(w, "\n")
 }
 
@@ -87,14 +76,66 @@ func (b *Base) Display() {
 }
 
 func (b *Base) displayHead(w io.Writer) {
+	r := GetRenderer()
+
+	fmt.Fprintf(
+		w,
+		"%s        %s\n",
+		r.Style(STYLE_KEY, "Level:"),
+		r.Level(b.level, b.level),
+	)
+
+	if b.TError != nil {
+		fmt.Fprintf(
+			w,
+			"%s         %s\n",
+			r.Style(STYLE_KEY, "Time:"),
+			r.Style(STYLE_ERROR, b.TError.Error()),
+		)
+	} else {
+		b.displayTime(w)
+	}
+
+	fmt.Fprintf(w, "%s       ", r.Style(STYLE_KEY, "Caller:"))
+	b.caller.displayTo(w)
+	b.caller.Frame().displaySource(w)
+
+	fmt.Fprintf(
+		w,
+		"\n%s\n%s\n",
+		r.Style(STYLE_KEY, "Message:"),
+		r.Style(STYLE_PLAIN, b.message),
+	)
+}
+
+// Show the time in UTC and as it is shown elsewhere, along with how
+// long ago it was.
+func (b *Base) displayTime(w io.Writer) {
+	r := GetRenderer()
+	zone := DisplayZone(b.TStamp)
+	label := fmt.Sprintf("Time (%s):", zone)
+
 	fmt.Fprintf(
 		w,
-		"\x1b[1;36mLevel:\x1b[0m        %s\n\x1b[1;36mTime (UTC):\x1b[0m   %v\n\x1b[1;36mTime (Local):\x1b[0m %v\n\x1b[1;36mCaller:\x1b[0m       %s\n\n\x1b[1;36mMessage:\x1b[0m\n%s\n",
-		b.Level,
-		b.TStamp.UTC().Format(time.RFC1123),
-		b.TStamp.Local().Format(time.RFC1123),
-		b.Caller,
-		b.Message,
+		"%s   %s\n",
+		r.Style(STYLE_KEY, "Time (UTC):"),
+		r.Style(STYLE_PLAIN, formatLayout(b.TStamp.UTC())),
+	)
+
+	if zone != TIMEZONE_UTC {
+		fmt.Fprintf(
+			w,
+			"%s %s\n",
+			r.Style(STYLE_KEY, fmt.Sprintf("%-13s", label)),
+			r.Style(STYLE_PLAIN, FormatAbsoluteTime(b.TStamp)),
+		)
+	}
+
+	fmt.Fprintf(
+		w,
+		"%s          %s\n",
+		r.Style(STYLE_KEY, "Age:"),
+		r.Style(STYLE_PLAIN, RelativeTime(b.TStamp, time.Now())),
 	)
 }
 
@@ -103,16 +144,35 @@ func (b *Base) displayRest(w io.Writer) {
 		return
 	}
 
-	// Meh.
-	keys := make([]string, 0, len(b.Rest))
-	for k := range b.Rest {
+	r := GetRenderer()
+	flat := b.Rest.Flatten()
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
 	fmt.Fprintf(w, "\n")
 	for _, k := range keys {
-		fmt.Fprintf(w, "\x1b[1;36m%s:\x1b[0m %v\n", k, utils.ValueOf(b.Rest[k]))
+		fmt.Fprintf(
+			w,
+			"%s %s\n",
+			r.Style(STYLE_KEY, k+":"),
+			r.Style(STYLE_PLAIN, ValueString(flat[k])),
+		)
+	}
+}
+
+func (b *Base) displayAttached(w io.Writer) {
+	if len(b.Attached) == 0 {
+		return
+	}
+
+	r := GetRenderer()
+
+	fmt.Fprintf(w, "\n%s\n", r.Style(STYLE_KEY, "Attached:"))
+	for idx := range b.Attached {
+		fmt.Fprintf(w, "%s\n", r.Style(STYLE_PLAIN, b.Attached[idx]))
 	}
 }
 
@@ -120,26 +180,138 @@ func (b *Base) SetRest(rest Line) {
 	b.Rest = rest
 }
 
+func (b *Base) Attach(lines []string) {
+	b.Attached = lines
+}
+
 func (b *Base) Compose(key string, value interface{}) bool {
+	if !b.compose(key, value) {
+		return false
+	}
+
+	b.keep(key, value)
+
+	return true
+}
+
+func (b *Base) compose(key string, value interface{}) bool {
 	switch key {
-	case "level":
-		b.Level = strings.ToUpper(value.(string))
+	case FIELD_LEVEL:
+		if level, ok := NormaliseLevel(value); ok {
+			b.level = level
+		} else {
+			b.level = strings.ToUpper(ValueString(value))
+		}
 		return true
 
-	case "ts":
-		b.TStamp = FloatToTime(value.(float64))
-		return true
+	case FIELD_TIME:
+		b.TStamp, b.TEncoding, b.TError = DecodeTime(value)
 
-	case "caller":
-		b.Caller = value.(string)
+		// Leave undecodable timestamps in the rest so they are shown.
+		return b.TError == nil
+
+	case FIELD_CALLER:
+		b.caller = ParseCallerValue(value)
 		return true
 
-	case "msg":
-		b.Message = value.(string)
+	case FIELD_MESSAGE:
+		b.message = ValueString(value)
 		return true
 	}
 
 	return false
 }
 
+// Remember the value a logical field was composed from, so that the
+// entity can be written out as it was read.
+func (b *Base) keep(field string, value interface{}) {
+	if b.original == nil {
+		b.original = map[string]interface{}{}
+	}
+
+	b.original[field] = value
+}
+
+func (b *Base) Level() string {
+	return b.level
+}
+
+func (b *Base) Severity() Severity {
+	return LevelSeverity(b.level)
+}
+
+// Return the entity's time, and whether it has a valid one.
+func (b *Base) Time() (time.Time, bool) {
+	return b.TStamp, b.TError == nil && !b.TStamp.IsZero()
+}
+
+func (b *Base) Message() string {
+	return b.message
+}
+
+func (b *Base) Caller() Caller {
+	return b.caller
+}
+
+// Look up a field by logical name or by path into the rest of the
+// entity.
+//
+// Logical fields have typed values: the time is a `time.Time` and the
+// caller a `Caller`, whose components may be given as `caller.line`
+// and so on.
+func (b *Base) Field(path string) (interface{}, bool) {
+	switch path {
+	case FIELD_LEVEL:
+		return b.level, b.level != ""
+
+	case FIELD_TIME:
+		return b.Time()
+
+	case FIELD_CALLER:
+		return b.caller, !b.caller.IsZero()
+
+	case FIELD_MESSAGE:
+		return b.message, b.message != ""
+	}
+
+	if strings.HasPrefix(path, FIELD_CALLER+".") {
+		return b.caller.Component(strings.TrimPrefix(path, FIELD_CALLER+"."))
+	}
+
+	return b.Rest.Get(path)
+}
+
+// Return the logical fields that are set, followed by the rest of the
+// entity flattened into paths in sorted order.
+func (b *Base) Fields() []Field {
+	return append(b.logicalFields(), b.restFields()...)
+}
+
+func (b *Base) logicalFields() []Field {
+	fields := []Field{}
+
+	for _, name := range []string{FIELD_LEVEL, FIELD_TIME, FIELD_CALLER, FIELD_MESSAGE} {
+		if value, ok := b.Field(name); ok {
+			fields = append(fields, Field{Path: name, Value: value})
+		}
+	}
+
+	return fields
+}
+
+func (b *Base) restFields() []Field {
+	if len(b.Rest) == 0 {
+		return []Field{}
+	}
+
+	flat := b.Rest.Flatten()
+	fields := make([]Field, 0, len(flat))
+
+	for _, path := range b.Rest.Paths() {
+		fields = append(fields, Field{Path: path, Value: flat[path]})
+	}
+
+	return fields
+}
+
 /* base.go ends here. */
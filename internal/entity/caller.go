@@ -0,0 +1,209 @@
+/*
+ * caller.go --- Structured caller.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The location that wrote a log entry.
+//
+// Callers are parsed from zap's short `pkg/file.go:123` form, and from
+// the full paths written by `FullCallerEncoder`.  `Raw` is the caller as
+// it appeared in the log.
+type Caller struct {
+	Package  string
+	File     string
+	Line     int
+	Function string
+	Raw      string
+}
+
+// Directories after which a full path names an import path.
+var callerRoots []string = []string{
+	"/pkg/mod/",
+	"/src/",
+}
+
+// Parse a caller such as `server/handler.go:123`.
+func ParseCaller(raw string) Caller {
+	caller := Caller{Raw: raw}
+	file := strings.TrimSpace(raw)
+
+	if pos := strings.LastIndex(file, ":"); pos > -1 {
+		if line, err := strconv.Atoi(file[pos+1:]); err == nil {
+			caller.Line = line
+			file = file[:pos]
+		}
+	}
+
+	caller.File = file
+	caller.Package = callerPackage(file)
+
+	return caller
+}
+
+// Build a caller from a decoded value, which is either a string or an
+// object with `file`, `line` and `function` fields.
+func ParseCallerValue(value interface{}) Caller {
+	switch val := value.(type) {
+	case string:
+		return ParseCaller(val)
+
+	case map[string]interface{}:
+		caller := Caller{Raw: ValueString(val)}
+
+		if file, ok := val["file"].(string); ok {
+			caller.File = file
+			caller.Package = callerPackage(file)
+		}
+
+		switch line := val["line"].(type) {
+		case float64:
+			caller.Line = int(line)
+
+		case json.Number:
+			num, _ := line.Int64()
+			caller.Line = int(num)
+
+		case string:
+			caller.Line, _ = strconv.Atoi(line)
+		}
+
+		if function, ok := val["function"].(string); ok {
+			caller.Function = function
+		}
+
+		return caller
+	}
+
+	return ParseCaller(ValueString(value))
+}
+
+func callerPackage(file string) string {
+	file = moduleVersionRx.ReplaceAllString(strings.ReplaceAll(file, "\\", "/"), "")
+	dir := path.Dir(file)
+
+	if dir == "." || dir == "/" {
+		return ""
+	}
+
+	for _, root := range callerRoots {
+		if pos := strings.LastIndex(dir+"/", root); pos > -1 {
+			if pkg := strings.TrimSuffix((dir + "/")[pos+len(root):], "/"); pkg != "" {
+				return pkg
+			}
+		}
+	}
+
+	return path.Base(dir)
+}
+
+// Return the base name of the caller's file.
+func (c Caller) Name() string {
+	if c.File == "" {
+		return ""
+	}
+
+	return path.Base(strings.ReplaceAll(c.File, "\\", "/"))
+}
+
+func (c Caller) IsZero() bool {
+	return c.Raw == "" && c.File == ""
+}
+
+func (c Caller) String() string {
+	if c.Raw != "" {
+		return c.Raw
+	}
+
+	if c.Line > 0 {
+		return fmt.Sprintf("%s:%d", c.File, c.Line)
+	}
+
+	return c.File
+}
+
+// Return a component of the caller by name, as used in search fields
+// such as `caller.file`.
+func (c Caller) Component(name string) (interface{}, bool) {
+	switch name {
+	case "package":
+		return c.Package, c.Package != ""
+
+	case "file":
+		return c.File, c.File != ""
+
+	case "name":
+		return c.Name(), c.File != ""
+
+	case "line":
+		return c.Line, c.Line > 0
+
+	case "function":
+		return c.Function, c.Function != ""
+	}
+
+	return nil, false
+}
+
+// Return the caller as a stack frame, so its source can be found.
+func (c Caller) Frame() StacktraceLine {
+	return StacktraceLine{
+		Package:  c.Package,
+		Function: c.Function,
+		File:     c.File,
+		Line:     c.Line,
+	}
+}
+
+func (c Caller) displayTo(w io.Writer) {
+	r := GetRenderer()
+
+	if c.File == "" {
+		fmt.Fprintf(w, "%s\n", r.Style(STYLE_PLAIN, c.String()))
+		return
+	}
+
+	line := ""
+	if c.Line > 0 {
+		line = strconv.Itoa(c.Line)
+	}
+
+	fmt.Fprintf(
+		w,
+		"%s %s%s%s %s\n",
+		r.Style(STYLE_TRACE_FILE, c.File),
+		r.Style(STYLE_TRACE_PUNCT, "["),
+		r.Style(STYLE_TRACE_LINE, line),
+		r.Style(STYLE_TRACE_PUNCT, "]"),
+		r.Style(STYLE_DIM, c.Package),
+	)
+}
+
+/* caller.go ends here. */
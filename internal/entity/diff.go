@@ -0,0 +1,210 @@
+/*
+ * diff.go --- Entity diffs.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"github.com/Asmodai/gohacks/utils"
+
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	DIFF_SAME = iota
+	DIFF_CHANGED
+	DIFF_LEFT
+	DIFF_RIGHT
+)
+
+type DiffKind int
+
+// The difference in one field between two entities.  `Left` or `Right`
+// is nil when the field is only in the other entity.
+type FieldDiff struct {
+	Path  string
+	Kind  DiffKind
+	Left  interface{}
+	Right interface{}
+}
+
+func diffMaps(left, right map[string]interface{}) []FieldDiff {
+	paths := map[string]bool{}
+	for k := range left {
+		paths[k] = true
+	}
+	for k := range right {
+		paths[k] = true
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for k := range paths {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	diffs := make([]FieldDiff, 0, len(sorted))
+	for _, path := range sorted {
+		lval, lok := left[path]
+		rval, rok := right[path]
+		diff := FieldDiff{Path: path, Left: lval, Right: rval}
+
+		switch {
+		case !rok:
+			diff.Kind = DIFF_LEFT
+
+		case !lok:
+			diff.Kind = DIFF_RIGHT
+
+		case ValueString(exportValue(lval)) == ValueString(exportValue(rval)):
+			diff.Kind = DIFF_SAME
+
+		default:
+			diff.Kind = DIFF_CHANGED
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs
+}
+
+// Compare two lines field by field, using flattened paths.
+func DiffLines(left, right Line) []FieldDiff {
+	return diffMaps(left.Flatten(), right.Flatten())
+}
+
+// Compare two entities field by field.
+func DiffEntities(left, right Entity) []FieldDiff {
+	fields := func(ent Entity) map[string]interface{} {
+		result := map[string]interface{}{}
+
+		for _, field := range ent.Fields() {
+			result[field.Path] = field.Value
+		}
+
+		return result
+	}
+
+	return diffMaps(fields(left), fields(right))
+}
+
+// Return the widths of the path and value columns of a diff.
+func diffWidths(diffs []FieldDiff, width int) (int, int) {
+	pathWidth := 0
+
+	for idx := range diffs {
+		if diffs[idx].Kind != DIFF_SAME && len(diffs[idx].Path) > pathWidth {
+			pathWidth = len(diffs[idx].Path)
+		}
+	}
+
+	colWidth := (width - pathWidth - 6) / 2
+	if colWidth < 10 {
+		colWidth = 10
+	}
+
+	return pathWidth, colWidth
+}
+
+// Render a diff as `DisplayDiffTo` does, under a header naming the two
+// sides.
+func DisplayDiffHeadedTo(w io.Writer, diffs []FieldDiff, width int, left, right string) {
+	r := GetRenderer()
+	pathWidth, colWidth := diffWidths(diffs, width)
+
+	column := func(text string) string {
+		return utils.Padable(utils.Elidable(text).Elide(colWidth)).Pad(colWidth)
+	}
+
+	fmt.Fprintf(
+		w,
+		"%s %s %s %s %s\n",
+		utils.Padable("").Pad(pathWidth),
+		r.Style(STYLE_TRACE_PUNCT, "|"),
+		r.Style(STYLE_DIFF_LEFT, column(left)),
+		r.Style(STYLE_TRACE_PUNCT, "|"),
+		r.Style(STYLE_DIFF_RIGHT, column(right)),
+	)
+
+	DisplayDiffTo(w, diffs, width)
+}
+
+// Render a diff in two columns, fitting the given width.  Fields that
+// are the same in both are collapsed into a count.
+func DisplayDiffTo(w io.Writer, diffs []FieldDiff, width int) {
+	r := GetRenderer()
+	same := 0
+	pathWidth, colWidth := diffWidths(diffs, width)
+
+	value := func(val interface{}, present bool) string {
+		text := "-"
+		if present {
+			text = strings.ReplaceAll(ValueString(exportValue(val)), "\n", `\n`)
+		}
+
+		return utils.Padable(utils.Elidable(text).Elide(colWidth)).Pad(colWidth)
+	}
+
+	for _, diff := range diffs {
+		left, right := STYLE_PLAIN, STYLE_PLAIN
+
+		switch diff.Kind {
+		case DIFF_SAME:
+			same++
+			continue
+
+		case DIFF_CHANGED:
+			left, right = STYLE_DIFF_LEFT, STYLE_DIFF_RIGHT
+
+		case DIFF_LEFT:
+			left = STYLE_DIFF_LEFT
+
+		case DIFF_RIGHT:
+			right = STYLE_DIFF_RIGHT
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s %s %s %s %s\n",
+			r.Style(STYLE_KEY, utils.Padable(diff.Path).Pad(pathWidth)),
+			r.Style(STYLE_TRACE_PUNCT, "|"),
+			r.Style(Style(left), value(diff.Left, diff.Kind != DIFF_RIGHT)),
+			r.Style(STYLE_TRACE_PUNCT, "|"),
+			r.Style(Style(right), value(diff.Right, diff.Kind != DIFF_LEFT)),
+		)
+	}
+
+	switch same {
+	case 0:
+
+	case 1:
+		fmt.Fprintf(w, "%s\n", r.Style(STYLE_DIM, "1 identical field."))
+
+	default:
+		fmt.Fprintf(w, "%s\n", r.Style(STYLE_DIM, fmt.Sprintf("%d identical fields.", same)))
+	}
+}
+
+/* diff.go ends here. */
@@ -24,14 +24,30 @@ package entity
 
 import (
 	"io"
+	"time"
 )
 
+// A field of an entity, named by its logical name or path.
+type Field struct {
+	Path  string
+	Value interface{}
+}
+
 type Entity interface {
+	Level() string
+	Severity() Severity
+	Time() (time.Time, bool)
+	Message() string
+	Caller() Caller
+	Field(string) (interface{}, bool)
+	Fields() []Field
+
 	Short(int) string
 	Display()
 	DisplayTo(io.Writer)
 	Compose(string, interface{}) bool
 	SetRest(Line)
+	Attach([]string)
 }
 
 /* entity.go ends here. */
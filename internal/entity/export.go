@@ -0,0 +1,531 @@
+/*
+ * export.go --- Export entities.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Key under which continuation lines are written.
+	EXPORT_ATTACHED string = "attached"
+
+	EXPORT_JSON   string = "json"
+	EXPORT_NDJSON string = "ndjson"
+	EXPORT_CSV    string = "csv"
+	EXPORT_YAML   string = "yaml"
+)
+
+var (
+	// Columns used by CSV exports when none are given.
+	DefaultColumns []string = []string{
+		FIELD_TIME,
+		FIELD_LEVEL,
+		FIELD_CALLER,
+		FIELD_MESSAGE,
+	}
+
+	// YAML keys that need no quoting.
+	yamlPlainKeyRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+)
+
+// An exporter writes entities in a machine-readable form.
+type Exporter interface {
+	Name() string
+
+	// Write anything needed before the first entity.
+	Begin(io.Writer) error
+
+	Export(io.Writer, Entity) error
+
+	// Write anything needed after the last entity.
+	End(io.Writer) error
+}
+
+// Return the names of all exporters, suitable for usage messages.
+func ExporterNames() []string {
+	return []string{EXPORT_CSV, EXPORT_JSON, EXPORT_NDJSON, EXPORT_YAML}
+}
+
+// Create an exporter by name.
+//
+// JSON, NDJSON and YAML exports write entities with the keys of the
+// given schema.  CSV exports write the given columns, which are field
+// paths as accepted by `Entity.Field`.
+func NewExporter(name string, schema *Schema, columns []string) (Exporter, error) {
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+
+	switch strings.ToLower(name) {
+	case EXPORT_JSON:
+		return &JSONExporter{schema: schema}, nil
+
+	case EXPORT_NDJSON:
+		return &NDJSONExporter{schema: schema}, nil
+
+	case EXPORT_CSV:
+		if len(columns) == 0 {
+			columns = DefaultColumns
+		}
+
+		return &CSVExporter{columns: columns}, nil
+
+	case EXPORT_YAML:
+		return &YAMLExporter{schema: schema}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown export format '%s', must be one of: %s",
+		name,
+		strings.Join(ExporterNames(), ", "),
+	)
+}
+
+// ==================================================================
+// {{{ Records:
+
+// Entities that can be written out with the keys of a schema.
+type recordable interface {
+	record(*Schema) []Field
+}
+
+// Return the entity as a list of top-level keys and values in the
+// order the schema's library writes them.
+//
+// Logical fields are written with the values they were read from, so
+// that exported lines are the same shape as the original ones.
+func Record(ent Entity, schema *Schema) []Field {
+	if rec, ok := ent.(recordable); ok {
+		return rec.record(schema)
+	}
+
+	return ent.Fields()
+}
+
+// Convert a typed field value back to one that can be encoded.
+func exportValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case time.Time:
+		return DisplayTime(val).Format(time.RFC3339Nano)
+
+	case Caller:
+		return val.String()
+
+	case Stacktrace:
+		return val.String()
+
+	case json.Number:
+		// Written as it was read, so that large integers survive.
+		return val
+	}
+
+	return value
+}
+
+func schemaKey(schema *Schema, field string) string {
+	if key, ok := schema.Key(field); ok {
+		return key
+	}
+
+	return field
+}
+
+func (b *Base) recordField(schema *Schema, field string) (Field, bool) {
+	if value, ok := b.original[field]; ok {
+		return Field{Path: schemaKey(schema, field), Value: value}, true
+	}
+
+	if value, ok := b.Field(field); ok {
+		return Field{Path: schemaKey(schema, field), Value: exportValue(value)}, true
+	}
+
+	return Field{}, false
+}
+
+func (b *Base) recordLogical(schema *Schema) []Field {
+	fields := []Field{}
+
+	for _, name := range []string{FIELD_LEVEL, FIELD_TIME, FIELD_CALLER, FIELD_MESSAGE} {
+		if field, ok := b.recordField(schema, name); ok {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+func (b *Base) recordRest() []Field {
+	keys := make([]string, 0, len(b.Rest))
+	for k := range b.Rest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]Field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, Field{Path: k, Value: b.Rest[k]})
+	}
+
+	return fields
+}
+
+// Continuation lines are written as an array, so that none are lost.
+func (b *Base) recordAttached(fields []Field) []Field {
+	if len(b.Attached) == 0 {
+		return fields
+	}
+
+	return append(fields, Field{Path: EXPORT_ATTACHED, Value: b.Attached})
+}
+
+func (b *Base) record(schema *Schema) []Field {
+	return b.recordAttached(append(b.recordLogical(schema), b.recordRest()...))
+}
+
+// Stack traces are written last, as zap does.  Continuation lines that
+// became the trace are not written again.
+func (t *Traced) record(schema *Schema) []Field {
+	fields := append(t.recordLogical(schema), t.recordRest()...)
+
+	if value, ok := t.original[FIELD_STACKTRACE]; ok {
+		fields = append(fields, Field{Path: schemaKey(schema, FIELD_STACKTRACE), Value: value})
+	} else if t.Trace.Len() > 0 {
+		fields = append(fields, Field{Path: schemaKey(schema, FIELD_STACKTRACE), Value: t.Trace.String()})
+	}
+
+	if t.attachedTrace {
+		return fields
+	}
+
+	return t.recordAttached(fields)
+}
+
+// Lines that could not be decoded are written as a message.
+func (r *Raw) record(schema *Schema) []Field {
+	return r.recordAttached([]Field{{Path: schemaKey(schema, FIELD_MESSAGE), Value: string(r.Data)}})
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ JSON:
+
+// Encode a value as JSON without escaping HTML characters.
+func marshalJSON(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// Encode fields as a JSON object, keeping their order.
+func marshalRecord(fields []Field) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for idx := range fields {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := marshalJSON(fields[idx].Path)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := marshalJSON(fields[idx].Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// Writes one JSON object per line, as zap does.
+type NDJSONExporter struct {
+	schema *Schema
+}
+
+func (e *NDJSONExporter) Name() string {
+	return EXPORT_NDJSON
+}
+
+func (e *NDJSONExporter) Begin(w io.Writer) error {
+	return nil
+}
+
+func (e *NDJSONExporter) Export(w io.Writer, ent Entity) error {
+	data, err := marshalRecord(Record(ent, e.schema))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+
+	return err
+}
+
+func (e *NDJSONExporter) End(w io.Writer) error {
+	return nil
+}
+
+// Writes a JSON array of objects.
+type JSONExporter struct {
+	schema *Schema
+	count  int
+}
+
+func (e *JSONExporter) Name() string {
+	return EXPORT_JSON
+}
+
+func (e *JSONExporter) Begin(w io.Writer) error {
+	e.count = 0
+	_, err := fmt.Fprintf(w, "[")
+
+	return err
+}
+
+func (e *JSONExporter) Export(w io.Writer, ent Entity) error {
+	data, err := marshalRecord(Record(ent, e.schema))
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if e.count == 0 {
+		sep = ""
+	}
+	e.count++
+
+	_, err = fmt.Fprintf(w, "%s\n  %s", sep, data)
+
+	return err
+}
+
+func (e *JSONExporter) End(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "\n]\n")
+
+	return err
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ CSV:
+
+// Writes the chosen columns, with a header row.
+type CSVExporter struct {
+	columns []string
+	writer  *csv.Writer
+}
+
+func (e *CSVExporter) Name() string {
+	return EXPORT_CSV
+}
+
+func (e *CSVExporter) Begin(w io.Writer) error {
+	e.writer = csv.NewWriter(w)
+
+	return e.writer.Write(e.columns)
+}
+
+func (e *CSVExporter) Export(w io.Writer, ent Entity) error {
+	row := make([]string, len(e.columns))
+
+	for idx := range e.columns {
+		if value, ok := ent.Field(e.columns[idx]); ok {
+			row[idx] = ValueString(exportValue(value))
+		}
+	}
+
+	return e.writer.Write(row)
+}
+
+func (e *CSVExporter) End(w io.Writer) error {
+	e.writer.Flush()
+
+	return e.writer.Error()
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ YAML:
+
+// Writes a YAML sequence of mappings.
+//
+// Strings are always double-quoted, and arrays of objects are written
+// in flow style, so no YAML library is needed.
+type YAMLExporter struct {
+	schema *Schema
+}
+
+func (e *YAMLExporter) Name() string {
+	return EXPORT_YAML
+}
+
+func (e *YAMLExporter) Begin(w io.Writer) error {
+	return nil
+}
+
+func (e *YAMLExporter) Export(w io.Writer, ent Entity) error {
+	var buf bytes.Buffer
+
+	if err := writeYAMLMapping(&buf, "- ", "  ", Record(ent, e.schema)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+func (e *YAMLExporter) End(w io.Writer) error {
+	return nil
+}
+
+func yamlKey(key string) string {
+	if yamlPlainKeyRx.MatchString(key) {
+		return key
+	}
+
+	return strconv.Quote(key)
+}
+
+func yamlScalar(value interface{}) (string, error) {
+	switch val := value.(type) {
+	case nil:
+		return "null", nil
+
+	case string:
+		return strconv.Quote(val), nil
+
+	case bool, float64, int, int64, json.Number:
+		return ValueString(val), nil
+	}
+
+	// JSON is valid YAML flow style.
+	data, err := marshalJSON(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func sortedFields(obj map[string]interface{}) []Field {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]Field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, Field{Path: k, Value: obj[k]})
+	}
+
+	return fields
+}
+
+// Write a block mapping.  The first key is prefixed with `first`, and
+// the rest with `indent`.
+func writeYAMLMapping(w io.Writer, first, indent string, fields []Field) error {
+	if len(fields) == 0 {
+		_, err := fmt.Fprintf(w, "%s{}\n", first)
+		return err
+	}
+
+	prefix := first
+	for _, field := range fields {
+		key := yamlKey(field.Path)
+
+		switch val := field.Value.(type) {
+		case map[string]interface{}:
+			if len(val) > 0 {
+				fmt.Fprintf(w, "%s%s:\n", prefix, key)
+				if err := writeYAMLMapping(w, indent+"  ", indent+"  ", sortedFields(val)); err != nil {
+					return err
+				}
+				prefix = indent
+				continue
+			}
+
+		case []interface{}:
+			if len(val) > 0 {
+				fmt.Fprintf(w, "%s%s:\n", prefix, key)
+				for idx := range val {
+					scalar, err := yamlScalar(val[idx])
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintf(w, "%s  - %s\n", indent, scalar)
+				}
+				prefix = indent
+				continue
+			}
+		}
+
+		scalar, err := yamlScalar(field.Value)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s%s: %s\n", prefix, key, scalar)
+		prefix = indent
+	}
+
+	return nil
+}
+
+// }}}
+// ==================================================================
+
+/* export.go ends here. */
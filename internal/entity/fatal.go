@@ -22,51 +22,8 @@
 
 package entity
 
-import (
-	"fmt"
-	"io"
-	"os"
-)
-
 type Fatal struct {
-	Base
-	Trace Stacktrace
-}
-
-func (f *Fatal) DisplayTo(w io.Writer) {
-	f.displayHead(w)
-	f.displayRest(w)
-	f.displayTrace(w)
-	fmt.Fprintf(w, "\n")
-}
-
-func (f *Fatal) Display() {
-	f.DisplayTo(os.Stdout)
-}
-
-func (f *Fatal) displayTrace(w io.Writer) {
-	if len(f.Trace) == 0 {
-		return
-	}
-
-	fmt.Fprintf(w, "\n\x1b[1;36mStack trace:\x1b[0m\n")
-	f.Trace.DisplayTo(w)
-}
-
-func (f *Fatal) Compose(key string, value interface{}) bool {
-	var seen bool
-
-	seen = f.Base.Compose(key, value)
-
-	if !seen {
-		switch key {
-		case "stacktrace":
-			f.Trace = NewStacktraceFromString(value.(string))
-			seen = true
-		}
-	}
-
-	return seen
+	Traced
 }
 
 /* fatal.go ends here. */
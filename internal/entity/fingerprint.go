@@ -0,0 +1,198 @@
+/*
+ * fingerprint.go --- Stack trace fingerprints and grouping.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FINGERPRINT_DEPTH int = 5
+)
+
+var (
+	// Type parameters, e.g. `Map[...]`.
+	typeParamsRx = regexp.MustCompile(`\[[^\]]*\]`)
+
+	// Go closures and wrappers, e.g. `main.func1.2` or `gowrap3`.
+	closureRx = regexp.MustCompile(`\.(func|gowrap)\d+(\.\d+)*`)
+
+	// Java lambdas, e.g. `lambda$main$0`.
+	lambdaRx = regexp.MustCompile(`\$\d+`)
+)
+
+// Return the frame's name with details that vary between builds and
+// goroutines removed.
+func (stl StacktraceLine) normalised() string {
+	name := stl.Name()
+
+	if name == "" {
+		name = stl.File
+	}
+
+	name = typeParamsRx.ReplaceAllString(name, "")
+	name = closureRx.ReplaceAllString(name, ".$1")
+	name = lambdaRx.ReplaceAllString(name, "")
+
+	return name
+}
+
+// Return the normalised frames used to fingerprint the trace.
+//
+// Go runtime frames at the top of the trace, such as `runtime.gopanic`,
+// are the same for every panic and so are skipped.
+func (st Stacktrace) fingerprintFrames(depth int) []string {
+	frames := st.Frames()
+	names := []string{}
+
+	if st.Kind == TRACE_GO {
+		for len(frames) > 0 && (frames[0].Package == "runtime" || frames[0].Name() == "panic") {
+			frames = frames[1:]
+		}
+	}
+
+	for idx := range frames {
+		if depth > 0 && len(names) >= depth {
+			break
+		}
+
+		names = append(names, frames[idx].normalised())
+	}
+
+	return names
+}
+
+// Return a fingerprint of the top `depth` frames of the trace.
+//
+// Traces with the same fingerprint are from the same place, even if
+// line numbers, arguments or goroutines differ.  A depth of zero uses
+// every frame.  Traces with no frames have an empty fingerprint.
+func (st Stacktrace) Fingerprint(depth int) string {
+	names := st.fingerprintFrames(depth)
+	if len(names) == 0 {
+		return ""
+	}
+
+	sum := sha1.Sum([]byte(st.Kind + "\n" + strings.Join(names, "\n")))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// A group of entities with the same stack trace fingerprint.
+//
+// `Entity` is the first entity seen in the group, and `Trace` is its
+// stack trace.
+type TraceGroup struct {
+	Fingerprint string
+	Count       int
+	First       time.Time
+	Last        time.Time
+	Entity      Entity
+	Trace       Stacktrace
+}
+
+func (tg *TraceGroup) seen(when time.Time) {
+	if tg.First.IsZero() || when.Before(tg.First) {
+		tg.First = when
+	}
+
+	if tg.Last.IsZero() || when.After(tg.Last) {
+		tg.Last = when
+	}
+}
+
+type TraceGrouper struct {
+	depth  int
+	groups map[string]*TraceGroup
+}
+
+func NewTraceGrouper(depth int) *TraceGrouper {
+	return &TraceGrouper{
+		depth:  depth,
+		groups: map[string]*TraceGroup{},
+	}
+}
+
+// Add an entity to its group.
+//
+// Returns false if the entity has no stack trace.
+func (tg *TraceGrouper) Add(ent Entity) bool {
+	value, _ := ent.Field(FIELD_STACKTRACE)
+
+	trace, ok := value.(Stacktrace)
+	if !ok {
+		return false
+	}
+
+	fp := trace.Fingerprint(tg.depth)
+	if fp == "" {
+		return false
+	}
+
+	group, found := tg.groups[fp]
+	if !found {
+		group = &TraceGroup{
+			Fingerprint: fp,
+			Entity:      ent,
+			Trace:       trace,
+		}
+		tg.groups[fp] = group
+	}
+
+	group.Count++
+	if when, ok := ent.Time(); ok {
+		group.seen(when)
+	}
+
+	return true
+}
+
+// Return the groups, largest first.
+func (tg *TraceGrouper) Groups() []*TraceGroup {
+	groups := make([]*TraceGroup, 0, len(tg.groups))
+
+	for _, group := range tg.groups {
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+
+		if !groups[i].First.Equal(groups[j].First) {
+			return groups[i].First.Before(groups[j].First)
+		}
+
+		return groups[i].Fingerprint < groups[j].Fingerprint
+	})
+
+	return groups
+}
+
+/* fingerprint.go ends here. */
@@ -0,0 +1,190 @@
+/*
+ * format.go --- Log line formats.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FORMAT_AUTO string = "auto"
+
+	// Number of lines sampled when detecting a format.
+	FORMAT_SAMPLE_SIZE int = 20
+)
+
+// A format decodes a single line of a log into a `Line`.
+type Format interface {
+	Name() string
+	Decode(string) (Line, error)
+}
+
+// Formats that dictate their own schema.
+type SchemaProvider interface {
+	Schema() *Schema
+}
+
+// ==================================================================
+// {{{ JSON:
+
+type JSONFormat struct {
+}
+
+func (f *JSONFormat) Name() string {
+	return "json"
+}
+
+func (f *JSONFormat) Decode(data string) (Line, error) {
+	var line Line = Line{}
+
+	// Numbers are kept as written, so that large integers such as IDs
+	// and nanosecond timestamps keep their precision.
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&line); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(data[dec.InputOffset():]) != "" {
+		return nil, fmt.Errorf("Unexpected data after JSON object")
+	}
+
+	return line, nil
+}
+
+// }}}
+// ==================================================================
+
+var (
+	FormatJSON   Format = &JSONFormat{}
+	FormatLogfmt Format = &LogfmtFormat{}
+
+	// Formats in order of preference when detection is ambiguous.
+	formats []Format = []Format{
+		FormatJSON,
+		FormatLogfmt,
+		FormatCombined,
+		FormatRFC5424,
+		FormatRFC3164,
+		FormatGoLog,
+	}
+)
+
+func DefaultFormat() Format {
+	return FormatJSON
+}
+
+// Return the names of all known formats, suitable for usage messages.
+func FormatNames() []string {
+	names := make([]string, 0, len(formats))
+
+	for idx := range formats {
+		names = append(names, formats[idx].Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func FindFormat(name string) (Format, error) {
+	lname := strings.ToLower(name)
+
+	for idx := range formats {
+		if formats[idx].Name() == lname {
+			return formats[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown format '%s', must be one of: %s",
+		name,
+		strings.Join(FormatNames(), ", "),
+	)
+}
+
+// Register a format, replacing any existing format of the same name.
+func RegisterFormat(format Format) {
+	for idx := range formats {
+		if formats[idx].Name() == format.Name() {
+			formats[idx] = format
+			return
+		}
+	}
+
+	formats = append(formats, format)
+}
+
+// Register formats from the given definitions.
+func RegisterFormatDefinitions(defs []FormatDefinition) error {
+	for idx := range defs {
+		format, err := NewRegexFormat(defs[idx])
+		if err != nil {
+			return err
+		}
+
+		RegisterFormat(format)
+	}
+
+	return nil
+}
+
+// Detect the format of a log by sampling its lines.
+//
+// The format that decodes the most lines wins.  If nothing decodes, the
+// default format is returned.
+func DetectFormat(lines []string) Format {
+	scores := make([]int, len(formats))
+	sampled := 0
+
+	for idx := range lines {
+		if sampled == FORMAT_SAMPLE_SIZE {
+			break
+		}
+
+		for fidx := range formats {
+			if _, err := formats[fidx].Decode(lines[idx]); err == nil {
+				scores[fidx]++
+			}
+		}
+		sampled++
+	}
+
+	best := 0
+	for idx := range scores {
+		if scores[idx] > scores[best] {
+			best = idx
+		}
+	}
+
+	if scores[best] == 0 {
+		return DefaultFormat()
+	}
+
+	return formats[best]
+}
+
+/* format.go ends here. */
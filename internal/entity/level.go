@@ -0,0 +1,273 @@
+/*
+ * level.go --- Level registry.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Severity of a level, in increasing order.  Entities whose level is
+// not known have no severity.
+type Severity int
+
+const (
+	SEVERITY_NONE Severity = iota
+	SEVERITY_TRACE
+	SEVERITY_DEBUG
+	SEVERITY_INFO
+	SEVERITY_WARN
+	SEVERITY_ERROR
+	SEVERITY_DPANIC
+	SEVERITY_PANIC
+	SEVERITY_FATAL
+)
+
+const (
+	LEVEL_TRACE  string = "TRACE"
+	LEVEL_DEBUG  string = "DEBUG"
+	LEVEL_INFO   string = "INFO"
+	LEVEL_WARN   string = "WARN"
+	LEVEL_ERROR  string = "ERROR"
+	LEVEL_DPANIC string = "DPANIC"
+	LEVEL_PANIC  string = "PANIC"
+	LEVEL_FATAL  string = "FATAL"
+)
+
+var severities map[string]Severity = map[string]Severity{
+	LEVEL_TRACE:  SEVERITY_TRACE,
+	LEVEL_DEBUG:  SEVERITY_DEBUG,
+	LEVEL_INFO:   SEVERITY_INFO,
+	LEVEL_WARN:   SEVERITY_WARN,
+	LEVEL_ERROR:  SEVERITY_ERROR,
+	LEVEL_DPANIC: SEVERITY_DPANIC,
+	LEVEL_PANIC:  SEVERITY_PANIC,
+	LEVEL_FATAL:  SEVERITY_FATAL,
+}
+
+var (
+	levelLock sync.RWMutex
+
+	// Lowercase aliases for canonical levels.
+	levelAliases map[string]string = map[string]string{
+		"trace":       LEVEL_TRACE,
+		"verbose":     LEVEL_TRACE,
+		"debug":       LEVEL_DEBUG,
+		"dbg":         LEVEL_DEBUG,
+		"info":        LEVEL_INFO,
+		"information": LEVEL_INFO,
+		"notice":      LEVEL_INFO,
+		"warn":        LEVEL_WARN,
+		"warning":     LEVEL_WARN,
+		"err":         LEVEL_ERROR,
+		"error":       LEVEL_ERROR,
+		"dpanic":      LEVEL_DPANIC,
+		"panic":       LEVEL_PANIC,
+		"fatal":       LEVEL_FATAL,
+		"crit":        LEVEL_FATAL,
+		"critical":    LEVEL_FATAL,
+		"alert":       LEVEL_FATAL,
+		"emerg":       LEVEL_FATAL,
+		"emergency":   LEVEL_FATAL,
+	}
+
+	// Syslog severities, 0 to 7.
+	syslogLevels []string = []string{
+		LEVEL_FATAL,
+		LEVEL_FATAL,
+		LEVEL_FATAL,
+		LEVEL_ERROR,
+		LEVEL_WARN,
+		LEVEL_INFO,
+		LEVEL_INFO,
+		LEVEL_DEBUG,
+	}
+
+	// Bunyan and pino levels, 10 to 60.
+	bunyanLevels []string = []string{
+		LEVEL_TRACE,
+		LEVEL_DEBUG,
+		LEVEL_INFO,
+		LEVEL_WARN,
+		LEVEL_ERROR,
+		LEVEL_FATAL,
+	}
+)
+
+func (s Severity) String() string {
+	for name, sev := range severities {
+		if sev == s {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Return the canonical levels in order of severity.
+func LevelNames() []string {
+	names := make([]string, 0, len(severities))
+
+	for name := range severities {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return severities[names[i]] < severities[names[j]]
+	})
+
+	return names
+}
+
+// Register an alias, such as `verbose`, for a canonical level.
+func RegisterLevelAlias(alias, level string) error {
+	canon := strings.ToUpper(level)
+
+	if _, ok := severities[canon]; !ok {
+		return fmt.Errorf(
+			"Unknown level '%s' for alias '%s', must be one of: %s",
+			level,
+			alias,
+			strings.Join(LevelNames(), ", "),
+		)
+	}
+
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	levelAliases[strings.ToLower(alias)] = canon
+
+	return nil
+}
+
+// Map a syslog priority to a canonical level.  The priority is the
+// facility times eight plus the severity.
+func priorityLevel(pri int) (string, bool) {
+	if pri < 0 || pri > 191 {
+		return "", false
+	}
+
+	return syslogLevels[pri%8], true
+}
+
+// Map a numeric level to a canonical one.
+//
+// Numbers from 0 to 7 are syslog severities, and numbers from 10 up are
+// bunyan and pino levels, which fall in bands of ten.
+func numericLevel(num float64) (string, bool) {
+	if num != math.Trunc(num) || num < 0 {
+		return "", false
+	}
+
+	switch {
+	case num < float64(len(syslogLevels)):
+		return syslogLevels[int(num)], true
+
+	case num >= 10:
+		idx := int(num)/10 - 1
+		if idx >= len(bunyanLevels) {
+			idx = len(bunyanLevels) - 1
+		}
+
+		return bunyanLevels[idx], true
+	}
+
+	return "", false
+}
+
+// Return the canonical name for a level value, which may be a name, an
+// alias, or a number.
+func NormaliseLevel(value interface{}) (string, bool) {
+	switch val := value.(type) {
+	case float64:
+		return numericLevel(val)
+
+	case int:
+		return numericLevel(float64(val))
+
+	case json.Number:
+		if num, err := val.Float64(); err == nil {
+			return numericLevel(num)
+		}
+
+		return "", false
+
+	case string:
+		str := strings.TrimSpace(val)
+
+		if num, err := strconv.ParseFloat(str, 64); err == nil {
+			return numericLevel(num)
+		}
+
+		levelLock.RLock()
+		defer levelLock.RUnlock()
+
+		canon, ok := levelAliases[strings.ToLower(str)]
+
+		return canon, ok
+	}
+
+	return "", false
+}
+
+// Return the severity of a level value.
+func LevelSeverity(value interface{}) Severity {
+	if canon, ok := NormaliseLevel(value); ok {
+		return severities[canon]
+	}
+
+	return SEVERITY_NONE
+}
+
+// Parse a minimum level given by the user.  An empty name has no
+// minimum.
+func ParseMinLevel(name string) (Severity, error) {
+	if name == "" {
+		return SEVERITY_NONE, nil
+	}
+
+	sev := LevelSeverity(name)
+	if sev == SEVERITY_NONE {
+		return sev, fmt.Errorf(
+			"Unknown level '%s', must be one of: %s",
+			name,
+			strings.Join(LevelNames(), ", "),
+		)
+	}
+
+	return sev, nil
+}
+
+// Is an entity of the given severity shown with the given minimum?
+//
+// Entities whose level is not known are always shown.
+func (s Severity) Passes(min Severity) bool {
+	return s == SEVERITY_NONE || s >= min
+}
+
+/* level.go ends here. */
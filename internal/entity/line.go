@@ -25,24 +25,46 @@ package entity
 type Line map[string]interface{}
 
 func (l Line) Parse() Entity {
+	return l.ParseWith(DefaultSchema())
+}
+
+func (l Line) ParseWith(schema *Schema) Entity {
 	var rec Entity = nil
 	var seen bool
 
-	if level, ok := l["level"]; ok {
-		switch level.(string) {
-		case "debug":
+	key, _ := schema.Key(FIELD_LEVEL)
+	if level, ok := NormaliseLevel(l[key]); ok {
+		switch level {
+		case LEVEL_TRACE:
+			rec = &Trace{}
+		case LEVEL_DEBUG:
 			rec = &Debug{}
-		case "info":
+		case LEVEL_INFO:
 			rec = &Info{}
-		case "warn":
+		case LEVEL_WARN:
 			rec = &Warn{}
-		case "fatal":
+		case LEVEL_ERROR:
+			rec = &Error{}
+		case LEVEL_DPANIC:
+			rec = &DPanic{}
+		case LEVEL_PANIC:
+			rec = &Panic{}
+		case LEVEL_FATAL:
 			rec = &Fatal{}
 		}
 	}
 
+	if rec == nil {
+		rec = &Unknown{}
+	}
+
 	for k, v := range l {
-		seen = rec.Compose(k, v)
+		field, ok := schema.Field(k)
+		if !ok {
+			continue
+		}
+
+		seen = rec.Compose(field, v)
 
 		if seen {
 			delete(l, k)
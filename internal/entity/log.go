@@ -22,17 +22,17 @@
 
 package entity
 
-import (
-	"encoding/json"
-)
-
 type Log []Line
 
 func (l Log) Parse() []Entity {
+	return l.ParseWith(DefaultSchema())
+}
+
+func (l Log) ParseWith(schema *Schema) []Entity {
 	var arr []Entity = []Entity{}
 
 	for idx := range l {
-		if rec := l[idx].Parse(); rec != nil {
+		if rec := l[idx].ParseWith(schema); rec != nil {
 			arr = append(arr, rec)
 		}
 	}
@@ -40,30 +40,53 @@ func (l Log) Parse() []Entity {
 	return arr
 }
 
-func stringsToLog(lines []string) (Log, error) {
-	var result Log = Log{}
-	var line Line
+func ParseLog(lines []string) ([]Entity, error) {
+	return ParseLogWith(lines, DefaultSchema())
+}
 
-	for idx := range lines {
-		line = Line{}
+// Parse the given JSON lines using the given schema.
+//
+// Continuation lines are attached to the preceding entity using the
+// default assembler rules.
+func ParseLogWith(lines []string, schema *Schema) ([]Entity, error) {
+	return ParseEntries(DefaultAssembler().Assemble(lines), FormatJSON, schema), nil
+}
 
-		if err := json.Unmarshal([]byte(lines[idx]), &line); err != nil {
-			return nil, err
-		}
+// Parse assembled entries using the given format and schema.
+//
+// Entries that cannot be decoded are returned as `Raw` entities rather
+// than aborting the whole batch.  Entities are redacted if a redactor
+// is set.
+func ParseEntries(entries []Entry, format Format, schema *Schema) []Entity {
+	var arr []Entity = []Entity{}
+	var red *Redactor = GetRedactor()
+
+	for idx := range entries {
+		rec := ParseEntry(entries[idx], format, schema)
+		red.Redact(rec)
 
-		result = append(result, line)
+		arr = append(arr, rec)
 	}
 
-	return result, nil
+	return arr
 }
 
-func ParseLog(lines []string) ([]Entity, error) {
-	raw, err := stringsToLog(lines)
+// Parse a single assembled entry without redacting it.
+func ParseEntry(entry Entry, format Format, schema *Schema) Entity {
+	var rec Entity
+
+	line, err := format.Decode(entry.Head)
 	if err != nil {
-		return nil, err
+		rec = NewRaw([]byte(entry.Head), err)
+	} else {
+		rec = line.ParseWith(schema)
+	}
+
+	if len(entry.Attached) > 0 {
+		rec.Attach(entry.Attached)
 	}
 
-	return raw.Parse(), nil
+	return rec
 }
 
 /* log.go ends here. */
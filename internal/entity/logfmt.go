@@ -0,0 +1,143 @@
+/*
+ * logfmt.go --- logfmt line format.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrLogfmtNoPairs error = errors.New("No key=value pairs found")
+)
+
+// Decoder for logfmt lines, e.g.
+//
+//	level=info ts=2022-10-17T10:00:00Z msg="hello world" user=42
+//
+// All values are decoded as strings.  Keys without a value are given
+// an empty string.
+type LogfmtFormat struct {
+}
+
+func (f *LogfmtFormat) Name() string {
+	return "logfmt"
+}
+
+func (f *LogfmtFormat) Decode(data string) (Line, error) {
+	var line Line = Line{}
+	var pairs int = 0
+
+	pos := 0
+	end := len(data)
+
+	for pos < end {
+		// Skip whitespace.
+		if data[pos] == ' ' || data[pos] == '\t' {
+			pos++
+			continue
+		}
+
+		// Key.
+		start := pos
+		for pos < end && data[pos] != '=' && data[pos] != ' ' && data[pos] != '\t' {
+			if data[pos] == '"' {
+				return nil, fmt.Errorf("Unexpected '\"' in key at column %d", pos+1)
+			}
+			pos++
+		}
+		key := data[start:pos]
+
+		if pos == end || data[pos] != '=' {
+			line[key] = ""
+			continue
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("Missing key at column %d", pos+1)
+		}
+
+		// Skip the '='.
+		pos++
+		pairs++
+
+		if pos < end && data[pos] == '"' {
+			value, npos, err := f.quoted(data, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			if npos < end && data[npos] != ' ' && data[npos] != '\t' {
+				return nil, fmt.Errorf("Unexpected character after quoted value at column %d", npos+1)
+			}
+
+			line[key] = value
+			pos = npos
+			continue
+		}
+
+		start = pos
+		for pos < end && data[pos] != ' ' && data[pos] != '\t' {
+			pos++
+		}
+		line[key] = data[start:pos]
+	}
+
+	if pairs == 0 {
+		return nil, ErrLogfmtNoPairs
+	}
+
+	return line, nil
+}
+
+// Decode a quoted value starting at the given position, returning the
+// value and the position after the closing quote.
+func (f *LogfmtFormat) quoted(data string, pos int) (string, int, error) {
+	start := pos
+	pos++
+
+	for pos < len(data) {
+		switch data[pos] {
+		case '\\':
+			pos += 2
+			continue
+
+		case '"':
+			value, err := strconv.Unquote(data[start : pos+1])
+			if err != nil {
+				// Not a Go escape sequence, so keep the text as-is.
+				value = strings.ReplaceAll(data[start+1:pos], `\"`, `"`)
+			}
+
+			return value, pos + 1, nil
+		}
+
+		pos++
+	}
+
+	return "", 0, fmt.Errorf("Unterminated quoted value at column %d", start+1)
+}
+
+/* logfmt.go ends here. */
@@ -0,0 +1,108 @@
+/*
+ * logfmt_test.go --- Logfmt decoding tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogfmtDecode(t *testing.T) {
+	tests := []struct {
+		data string
+		want Line
+	}{
+		{
+			`level=info msg=hello user=42`,
+			Line{"level": "info", "msg": "hello", "user": "42"},
+		},
+		{
+			`msg="hello world"  	 ts=2022-10-17T10:00:00Z`,
+			Line{"msg": "hello world", "ts": "2022-10-17T10:00:00Z"},
+		},
+		{
+			`msg="say \"hi\"" path="C:\\temp"`,
+			Line{"msg": `say "hi"`, "path": `C:\temp`},
+		},
+		{
+			`msg="line one\nline two\ttabbed"`,
+			Line{"msg": "line one\nline two\ttabbed"},
+		},
+		{
+			`msg="caf\u00e9"`,
+			Line{"msg": "café"},
+		},
+		{
+			`re="\d+ \"x\""`,
+			Line{"re": `\d+ "x"`},
+		},
+		{
+			`msg="" empty= flag`,
+			Line{"msg": "", "empty": "", "flag": ""},
+		},
+		{
+			`url=http://host/a=b?c=d`,
+			Line{"url": "http://host/a=b?c=d"},
+		},
+		{
+			`msg="a = b"`,
+			Line{"msg": "a = b"},
+		},
+	}
+
+	format := &LogfmtFormat{}
+
+	for _, test := range tests {
+		line, err := format.Decode(test.data)
+		if err != nil {
+			t.Errorf("%q: %s", test.data, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(line, test.want) {
+			t.Errorf("%q: expected %#v, got %#v", test.data, test.want, line)
+		}
+	}
+}
+
+func TestLogfmtDecodeInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`just some words`,
+		`msg="unterminated`,
+		`msg="ends in a backslash\"`,
+		`msg="quoted"trailing`,
+		`"key"=value`,
+		`=value`,
+	}
+
+	format := &LogfmtFormat{}
+
+	for _, data := range tests {
+		if line, err := format.Decode(data); err == nil {
+			t.Errorf("%q: expected an error, got %#v", data, line)
+		}
+	}
+}
+
+/* logfmt_test.go ends here. */
@@ -0,0 +1,236 @@
+/*
+ * path.go --- Dotted field paths.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A segment of a field path, e.g. `tags[0]` is the key segment `tags`
+// followed by the index segment `0`.
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Split a path such as `http.request.method`, `tags[0]` or `tags[*]`
+// into segments.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment = []pathSegment{}
+
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		rest := ""
+
+		if pos := strings.Index(part, "["); pos > -1 {
+			key = part[:pos]
+			rest = part[pos:]
+		}
+
+		if key != "" {
+			segments = append(segments, pathSegment{key: key})
+		}
+
+		for rest != "" {
+			end := strings.Index(rest, "]")
+			if rest[0] != '[' || end == -1 {
+				return nil, fmt.Errorf("Invalid index in path '%s'", path)
+			}
+
+			idx := rest[1:end]
+			rest = rest[end+1:]
+
+			if idx == "*" {
+				segments = append(segments, pathSegment{isIndex: true, wildcard: true})
+				continue
+			}
+
+			num, err := strconv.Atoi(idx)
+			if err != nil || num < 0 {
+				return nil, fmt.Errorf("Invalid index '%s' in path '%s'", idx, path)
+			}
+
+			segments = append(segments, pathSegment{isIndex: true, index: num})
+		}
+	}
+
+	return segments, nil
+}
+
+func walkPath(value interface{}, segments []pathSegment, result []interface{}) []interface{} {
+	if len(segments) == 0 {
+		return append(result, value)
+	}
+
+	seg := segments[0]
+
+	switch val := value.(type) {
+	case Line:
+		return walkPath(map[string]interface{}(val), segments, result)
+
+	case map[string]interface{}:
+		if seg.isIndex {
+			return result
+		}
+
+		if next, ok := val[seg.key]; ok {
+			return walkPath(next, segments[1:], result)
+		}
+
+	case []interface{}:
+		if !seg.isIndex {
+			return result
+		}
+
+		if seg.wildcard {
+			for idx := range val {
+				result = walkPath(val[idx], segments[1:], result)
+			}
+
+			return result
+		}
+
+		if seg.index < len(val) {
+			return walkPath(val[seg.index], segments[1:], result)
+		}
+	}
+
+	return result
+}
+
+// Return all values found at the given path.
+//
+// A key that exists verbatim in the line, even if it contains dots, is
+// preferred over walking nested objects.  Paths with `[*]` may return
+// more than one value.
+func (l Line) GetAll(path string) []interface{} {
+	if val, ok := l[path]; ok {
+		return []interface{}{val}
+	}
+
+	segments, err := parsePath(path)
+	if err != nil {
+		return []interface{}{}
+	}
+
+	return walkPath(l, segments, []interface{}{})
+}
+
+// Return the first value found at the given path.
+func (l Line) Get(path string) (interface{}, bool) {
+	vals := l.GetAll(path)
+	if len(vals) == 0 {
+		return nil, false
+	}
+
+	return vals[0], true
+}
+
+func flatten(prefix string, value interface{}, result map[string]interface{}) {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			result[prefix] = val
+			return
+		}
+
+		for k, v := range val {
+			if prefix == "" {
+				flatten(k, v, result)
+			} else {
+				flatten(prefix+"."+k, v, result)
+			}
+		}
+
+	case []interface{}:
+		if len(val) == 0 {
+			result[prefix] = val
+			return
+		}
+
+		for idx := range val {
+			flatten(fmt.Sprintf("%s[%d]", prefix, idx), val[idx], result)
+		}
+
+	default:
+		result[prefix] = value
+	}
+}
+
+// Flatten nested objects and arrays into a map of paths to leaf values.
+func (l Line) Flatten() map[string]interface{} {
+	var result map[string]interface{} = map[string]interface{}{}
+
+	flatten("", map[string]interface{}(l), result)
+
+	return result
+}
+
+// Return the paths of all leaf values, sorted.
+func (l Line) Paths() []string {
+	flat := l.Flatten()
+	paths := make([]string, 0, len(flat))
+
+	for k := range flat {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Render a field value as text, suitable for display and searching.
+func ValueString(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+
+	case string:
+		return val
+
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+
+	case json.Number:
+		return val.String()
+
+	case time.Time:
+		return DisplayTime(val).Format(time.RFC3339Nano)
+
+	case map[string]interface{}, []interface{}:
+		if data, err := json.Marshal(val); err == nil {
+			return string(data)
+		}
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+/* path.go ends here. */
@@ -0,0 +1,104 @@
+/*
+ * raw.go --- Undecodable log entry type.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"github.com/Asmodai/gohacks/utils"
+
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	LEVEL_RAW string = "RAW"
+)
+
+// A line that could not be decoded.  The original bytes are kept so
+// that the line can still be viewed.
+type Raw struct {
+	Base
+	Data  []byte
+	Error error
+}
+
+func NewRaw(data []byte, err error) *Raw {
+	return &Raw{
+		Base: Base{
+			level:   LEVEL_RAW,
+			message: string(data),
+		},
+		Data:  data,
+		Error: err,
+	}
+}
+
+func (r *Raw) Short(width int) string {
+	return GetRenderer().Style(
+		STYLE_DIM,
+		fmt.Sprintf(
+			"%s %s",
+			utils.Padable(r.level).Pad(levelWidth),
+			utils.Elidable(string(r.Data)).Elide(width-(levelWidth+1)),
+		),
+	)
+}
+
+func (r *Raw) DisplayTo(w io.Writer) {
+	rnd := GetRenderer()
+
+	fmt.Fprintf(
+		w,
+		"%s        %s\n",
+		rnd.Style(STYLE_KEY, "Level:"),
+		rnd.Style(STYLE_PLAIN, r.level),
+	)
+
+	if r.Error != nil {
+		fmt.Fprintf(
+			w,
+			"%s        %s\n",
+			rnd.Style(STYLE_KEY, "Error:"),
+			rnd.Style(STYLE_ERROR, r.Error.Error()),
+		)
+	}
+
+	fmt.Fprintf(
+		w,
+		"\n%s\n%s\n",
+		rnd.Style(STYLE_KEY, "Data:"),
+		rnd.Style(STYLE_DIM, string(r.Data)),
+	)
+	r.displayAttached(w)
+	fmt.Fprintf(w, "\n")
+}
+
+func (r *Raw) Display() {
+	r.DisplayTo(os.Stdout)
+}
+
+func (r *Raw) Compose(key string, value interface{}) bool {
+	return false
+}
+
+/* raw.go ends here. */
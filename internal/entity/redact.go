@@ -0,0 +1,403 @@
+/*
+ * redact.go --- Redaction of sensitive values.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	REDACT_REPLACEMENT string = "[REDACTED]"
+)
+
+// A redaction rule.
+//
+// A rule with a `Field` glob, such as `*password*`, masks the whole
+// value of any field whose path or key matches it.  A rule with a
+// `Pattern` masks the parts of string values that match it.
+type RedactRule struct {
+	Name        string `json:"name"`
+	Field       string `json:"field"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	compiled *regexp.Regexp
+	check    func(string) bool
+}
+
+var (
+	BuiltinRedactRules []RedactRule = []RedactRule{
+		{Name: "password", Field: "*passw*"},
+		{Name: "secret", Field: "*secret*"},
+		{Name: "token", Field: "*token*"},
+		{Name: "authorization", Field: "authorization"},
+		{Name: "jwt", Pattern: `eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`},
+		{Name: "email", Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+		{Name: "credit-card", Pattern: `\b(?:\d[ -]?){12,18}\d\b`, check: luhnValid},
+		{Name: "ipv4", Pattern: `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`},
+	}
+)
+
+// Check a card number with the Luhn algorithm, so that most other long
+// numbers are left alone.  About one in ten passes anyway, which is why
+// patterns are only applied to string values and never to the time,
+// level or caller of a line.
+func luhnValid(text string) bool {
+	sum := 0
+	double := false
+
+	for idx := len(text) - 1; idx >= 0; idx-- {
+		if text[idx] < '0' || text[idx] > '9' {
+			continue
+		}
+
+		digit := int(text[idx] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+func (rr *RedactRule) replacement() string {
+	if rr.Replacement == "" {
+		return REDACT_REPLACEMENT
+	}
+
+	return rr.Replacement
+}
+
+func (rr *RedactRule) compile() error {
+	if rr.Field == "" && rr.Pattern == "" {
+		return fmt.Errorf("Redaction rule '%s' has neither a field nor a pattern", rr.Name)
+	}
+
+	if rr.Field != "" {
+		if _, err := path.Match(rr.Field, ""); err != nil {
+			return fmt.Errorf("Redaction rule '%s': %s", rr.Name, err.Error())
+		}
+	}
+
+	if rr.Pattern != "" {
+		re, err := regexp.Compile(rr.Pattern)
+		if err != nil {
+			return fmt.Errorf("Redaction rule '%s': %s", rr.Name, err.Error())
+		}
+		rr.compiled = re
+	}
+
+	return nil
+}
+
+// Does the rule mask the field at the given path?
+func (rr *RedactRule) matchField(fpath string) bool {
+	if rr.Field == "" {
+		return false
+	}
+
+	lpath := strings.ToLower(fpath)
+	glob := strings.ToLower(rr.Field)
+
+	if ok, _ := path.Match(glob, lpath); ok {
+		return true
+	}
+
+	// Match the last key of the path too.
+	key := lpath
+	if pos := strings.LastIndexAny(key, ".]"); pos > -1 {
+		key = key[pos+1:]
+	}
+
+	ok, _ := path.Match(glob, key)
+
+	return ok
+}
+
+func (rr *RedactRule) redactText(text string) string {
+	if rr.compiled == nil {
+		return text
+	}
+
+	return rr.compiled.ReplaceAllStringFunc(text, func(match string) string {
+		if rr.check != nil && !rr.check(match) {
+			return match
+		}
+
+		return rr.replacement()
+	})
+}
+
+// A set of redaction rules.
+type Redactor struct {
+	rules []RedactRule
+}
+
+// Create a redactor with the built-in rules and the given rules.  A
+// rule with the same name as a built-in rule replaces it.
+func NewRedactor(rules []RedactRule) (*Redactor, error) {
+	r := &Redactor{rules: []RedactRule{}}
+
+	for _, rule := range append(append([]RedactRule{}, BuiltinRedactRules...), rules...) {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+
+		replaced := false
+		for idx := range r.rules {
+			if rule.Name != "" && r.rules[idx].Name == rule.Name {
+				r.rules[idx] = rule
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			r.rules = append(r.rules, rule)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) fieldRule(fpath string) (*RedactRule, bool) {
+	for idx := range r.rules {
+		if r.rules[idx].matchField(fpath) {
+			return &r.rules[idx], true
+		}
+	}
+
+	return nil, false
+}
+
+// Mask the parts of the text matched by value patterns.
+func (r *Redactor) RedactText(text string) string {
+	if r == nil {
+		return text
+	}
+
+	for idx := range r.rules {
+		text = r.rules[idx].redactText(text)
+	}
+
+	return text
+}
+
+// Return a redacted copy of a decoded value found at the given path.
+func (r *Redactor) RedactValue(fpath string, value interface{}) interface{} {
+	if r == nil {
+		return value
+	}
+
+	if rule, ok := r.fieldRule(fpath); ok && fpath != "" {
+		return rule.replacement()
+	}
+
+	switch val := value.(type) {
+	case string:
+		return r.RedactText(val)
+
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			sub := k
+			if fpath != "" {
+				sub = fpath + "." + k
+			}
+
+			result[k] = r.RedactValue(sub, v)
+		}
+
+		return result
+
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for idx := range val {
+			result[idx] = r.RedactValue(fmt.Sprintf("%s[%d]", fpath, idx), val[idx])
+		}
+
+		return result
+	}
+
+	return value
+}
+
+// Return a redacted copy of a line.
+func (r *Redactor) RedactLine(line Line) Line {
+	if r == nil {
+		return line
+	}
+
+	return Line(r.RedactValue("", map[string]interface{}(line)).(map[string]interface{}))
+}
+
+// Mask a line of log text, given its decoded form and schema.
+//
+// The text keeps its original format.  Values of masked fields, and
+// string values matched by value patterns, are replaced where they
+// follow their key, as in `"key":"value"` or `key=value`.  Numbers and
+// the logical time, level and caller fields are not matched against
+// value patterns, so that timestamps survive.
+func (r *Redactor) RedactRaw(text string, line Line, schema *Schema) string {
+	if r == nil {
+		return text
+	}
+
+	logical := map[string]bool{}
+	if schema != nil {
+		for _, key := range []string{schema.Time, schema.Level, schema.Caller} {
+			if key != "" {
+				logical[key] = true
+			}
+		}
+	}
+
+	for fpath, value := range line.Flatten() {
+		var str, masked string
+
+		if rule, ok := r.fieldRule(fpath); ok {
+			str = ValueString(value)
+			masked = rule.replacement()
+		} else {
+			val, ok := value.(string)
+			if !ok || logical[fpath] {
+				continue
+			}
+
+			str = val
+			masked = r.RedactText(val)
+			if masked == str {
+				continue
+			}
+		}
+
+		key := fpath
+		if pos := strings.LastIndexAny(key, ".]"); pos > -1 {
+			key = key[pos+1:]
+		}
+
+		if key == "" || str == "" {
+			continue
+		}
+
+		// Strings appear escaped in JSON.
+		if data, err := json.Marshal(str); err == nil {
+			str = string(data[1 : len(data)-1])
+		}
+
+		if data, err := json.Marshal(masked); err == nil {
+			masked = string(data[1 : len(data)-1])
+		}
+
+		re, err := regexp.Compile(
+			`(` + regexp.QuoteMeta(key) + `"?\s*[:=]\s*"?)` + regexp.QuoteMeta(str),
+		)
+		if err != nil {
+			continue
+		}
+
+		text = re.ReplaceAllString(text, "${1}"+strings.ReplaceAll(masked, "$", "$$"))
+	}
+
+	return text
+}
+
+// Redact an entity in place.
+func (r *Redactor) Redact(ent Entity) {
+	if r == nil {
+		return
+	}
+
+	if red, ok := ent.(interface{ redact(*Redactor) }); ok {
+		red.redact(r)
+	}
+}
+
+func (b *Base) redact(r *Redactor) {
+	if rule, ok := r.fieldRule(FIELD_MESSAGE); ok {
+		b.message = rule.replacement()
+	} else {
+		b.message = r.RedactText(b.message)
+	}
+
+	b.Rest = r.RedactLine(b.Rest)
+
+	for idx := range b.Attached {
+		b.Attached[idx] = r.RedactText(b.Attached[idx])
+	}
+
+	for field, value := range b.original {
+		switch field {
+		case FIELD_TIME, FIELD_LEVEL, FIELD_CALLER:
+			if rule, ok := r.fieldRule(field); ok {
+				b.original[field] = rule.replacement()
+			}
+
+		default:
+			b.original[field] = r.RedactValue(field, value)
+		}
+	}
+}
+
+func (t *Traced) redact(r *Redactor) {
+	t.Base.redact(r)
+	t.Trace.Message = r.RedactText(t.Trace.Message)
+}
+
+func (raw *Raw) redact(r *Redactor) {
+	raw.Base.redact(r)
+	raw.Data = []byte(r.RedactText(string(raw.Data)))
+}
+
+var (
+	redactorLock sync.RWMutex
+	redactor     *Redactor = nil
+)
+
+// Set the redactor applied to parsed entities, or nil to disable
+// redaction.
+func SetRedactor(r *Redactor) {
+	redactorLock.Lock()
+	defer redactorLock.Unlock()
+
+	redactor = r
+}
+
+func GetRedactor() *Redactor {
+	redactorLock.RLock()
+	defer redactorLock.RUnlock()
+
+	return redactor
+}
+
+/* redact.go ends here. */
@@ -0,0 +1,99 @@
+/*
+ * redact_test.go --- Redaction tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"testing"
+)
+
+func TestRedactRawKeepsEpochMillis(t *testing.T) {
+	// A millisecond epoch that passes the Luhn check.
+	if !luhnValid("1650000000506") {
+		t.Fatal("Expected the timestamp to pass the Luhn check")
+	}
+
+	red, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := `{"level":"info","ts":1650000000506,"msg":"mail bob@example.com","card":"4111 1111 1111 1111"}`
+	want := `{"level":"info","ts":1650000000506,"msg":"mail [REDACTED]","card":"[REDACTED]"}`
+
+	line, err := FormatJSON.Decode(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := red.RedactRaw(text, line, SchemaZapProduction); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestRedactRawStringTime(t *testing.T) {
+	red, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := `ts=1650000000506 msg="token is 4111111111111111" password=hunter2`
+	want := `ts=1650000000506 msg="token is [REDACTED]" password=[REDACTED]`
+
+	line, err := FormatLogfmt.Decode(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := &Schema{Time: "ts", Message: "msg"}
+	if got := red.RedactRaw(text, line, schema); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestRedactEntityKeepsTime(t *testing.T) {
+	red, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := FormatJSON.Decode(`{"level":"info","ts":"1650000000506","msg":"hello"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent := line.ParseWith(SchemaZapProduction)
+	red.Redact(ent)
+
+	rec, ok := ent.(interface {
+		recordField(*Schema, string) (Field, bool)
+	})
+	if !ok {
+		t.Fatalf("Entity %T has no record fields", ent)
+	}
+
+	if field, _ := rec.recordField(SchemaZapProduction, FIELD_TIME); field.Value != "1650000000506" {
+		t.Errorf("Expected the time to be kept, got %v", field.Value)
+	}
+}
+
+/* redact_test.go ends here. */
@@ -0,0 +1,222 @@
+/*
+ * regex.go --- Regular expression line formats.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Definition of a format where a regular expression with named
+// captures turns each line into a `Line`.
+//
+// The capture names given for level, time, caller, message and stack
+// trace form the schema for the format.  The time capture is parsed
+// with the given layout.  Lines without a level are given the level
+// of their syslog priority, if there is a priority capture, or else the
+// default level, if any.
+type FormatDefinition struct {
+	Name         string `json:"name"`
+	Pattern      string `json:"pattern"`
+	TimeLayout   string `json:"time_layout"`
+	DefaultLevel string `json:"default_level"`
+	Level        string `json:"level"`
+	Time         string `json:"time"`
+	Caller       string `json:"caller"`
+	Message      string `json:"message"`
+	Stacktrace   string `json:"stacktrace"`
+	Priority     string `json:"priority"`
+}
+
+type RegexFormat struct {
+	def    FormatDefinition
+	re     *regexp.Regexp
+	names  []string
+	schema *Schema
+}
+
+func NewRegexFormat(def FormatDefinition) (*RegexFormat, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("Format definition has no name")
+	}
+
+	re, err := regexp.Compile(def.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Format '%s': %s", def.Name, err.Error())
+	}
+
+	return &RegexFormat{
+		def:   def,
+		re:    re,
+		names: re.SubexpNames(),
+		schema: &Schema{
+			Name:       def.Name,
+			Level:      def.Level,
+			Time:       def.Time,
+			Caller:     def.Caller,
+			Message:    def.Message,
+			Stacktrace: def.Stacktrace,
+		},
+	}, nil
+}
+
+func (f *RegexFormat) Name() string {
+	return f.def.Name
+}
+
+// Regular expression formats know their own schema.
+func (f *RegexFormat) Schema() *Schema {
+	return f.schema
+}
+
+func (f *RegexFormat) Decode(data string) (Line, error) {
+	var line Line = Line{}
+
+	match := f.re.FindStringSubmatch(data)
+	if match == nil {
+		return nil, fmt.Errorf("Line does not match format '%s'", f.def.Name)
+	}
+
+	for idx := range match {
+		if idx == 0 || f.names[idx] == "" || match[idx] == "" {
+			continue
+		}
+
+		line[f.names[idx]] = match[idx]
+	}
+
+	if f.def.Time != "" && f.def.TimeLayout != "" {
+		if val, ok := line[f.def.Time].(string); ok {
+			ts, err := time.Parse(f.def.TimeLayout, val)
+			if err == nil {
+				// Layouts without a year, such as in syslog.
+				if ts.Year() == 0 {
+					ts = fillYear(ts, time.Now())
+				}
+
+				line[f.def.Time] = ts
+			}
+		}
+	}
+
+	if f.def.Level != "" && f.def.Priority != "" {
+		if _, ok := line[f.def.Level]; !ok {
+			if val, ok := line[f.def.Priority].(string); ok {
+				if pri, err := strconv.Atoi(val); err == nil {
+					if level, ok := priorityLevel(pri); ok {
+						line[f.def.Level] = level
+					}
+				}
+			}
+		}
+	}
+
+	if f.def.Level != "" && f.def.DefaultLevel != "" {
+		if _, ok := line[f.def.Level]; !ok {
+			line[f.def.Level] = f.def.DefaultLevel
+		}
+	}
+
+	return line, nil
+}
+
+var (
+	FormatCombined = mustRegexFormat(FormatDefinition{
+		Name:         "combined",
+		Pattern:      `^(?P<remote_addr>\S+) \S+ (?P<remote_user>\S+) \[(?P<time>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d{3}) (?P<bytes>\S+)(?: "(?P<referer>[^"]*)" "(?P<user_agent>[^"]*)")?`,
+		TimeLayout:   "02/Jan/2006:15:04:05 -0700",
+		DefaultLevel: "info",
+		Level:        "level",
+		Time:         "time",
+		Message:      "request",
+	})
+
+	FormatRFC3164 = mustRegexFormat(FormatDefinition{
+		Name:         "rfc3164",
+		Pattern:      `^(?:<(?P<pri>\d{1,3})>)?(?P<time>[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (?P<host>\S+) (?P<app>[^:\[\s]+)(?:\[(?P<pid>\d+)\])?: (?P<msg>.*)$`,
+		TimeLayout:   "Jan _2 15:04:05",
+		DefaultLevel: "info",
+		Level:        "level",
+		Time:         "time",
+		Caller:       "app",
+		Message:      "msg",
+		Priority:     "pri",
+	})
+
+	FormatRFC5424 = mustRegexFormat(FormatDefinition{
+		Name:         "rfc5424",
+		Pattern:      `^<(?P<pri>\d{1,3})>1 (?P<time>\S+) (?P<host>\S+) (?P<app>\S+) (?P<pid>\S+) (?P<msgid>\S+) (?P<sd>-|(?:\[.*?\])+) ?(?P<msg>.*)$`,
+		TimeLayout:   time.RFC3339Nano,
+		DefaultLevel: "info",
+		Level:        "level",
+		Time:         "time",
+		Caller:       "app",
+		Message:      "msg",
+		Priority:     "pri",
+	})
+
+	FormatGoLog = mustRegexFormat(FormatDefinition{
+		Name:         "golog",
+		Pattern:      `^(?P<time>\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) (?:(?P<caller>[^\s:]+\.go:\d+): )?(?P<msg>.*)$`,
+		TimeLayout:   "2006/01/02 15:04:05.999999",
+		DefaultLevel: "info",
+		Level:        "level",
+		Time:         "time",
+		Caller:       "caller",
+		Message:      "msg",
+	})
+)
+
+// Give a timestamp without a year the year of the given time.  A
+// timestamp that would then be more than a day ahead of that time is
+// from the previous year, as with December lines read in January.
+func fillYear(ts time.Time, now time.Time) time.Time {
+	filled := time.Date(
+		now.Year(), ts.Month(), ts.Day(),
+		ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(),
+		ts.Location(),
+	)
+
+	if filled.After(now.Add(24 * time.Hour)) {
+		filled = time.Date(
+			now.Year()-1, ts.Month(), ts.Day(),
+			ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(),
+			ts.Location(),
+		)
+	}
+
+	return filled
+}
+
+func mustRegexFormat(def FormatDefinition) *RegexFormat {
+	format, err := NewRegexFormat(def)
+	if err != nil {
+		panic(err)
+	}
+
+	return format
+}
+
+/* regex.go ends here. */
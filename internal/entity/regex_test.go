@@ -0,0 +1,118 @@
+/*
+ * regex_test.go --- Regular expression format tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityLevel(t *testing.T) {
+	tests := []struct {
+		pri   int
+		level string
+		ok    bool
+	}{
+		{0, LEVEL_FATAL, true},
+		{2, LEVEL_FATAL, true},
+		{3, LEVEL_ERROR, true},
+		{4, LEVEL_WARN, true},
+		{5, LEVEL_INFO, true},
+		{6, LEVEL_INFO, true},
+		{7, LEVEL_DEBUG, true},
+		{165, LEVEL_INFO, true},
+		{191, LEVEL_DEBUG, true},
+		{192, "", false},
+		{-1, "", false},
+	}
+
+	for _, test := range tests {
+		level, ok := priorityLevel(test.pri)
+		if ok != test.ok || level != test.level {
+			t.Errorf("Priority %d: expected %q/%v, got %q/%v",
+				test.pri, test.level, test.ok, level, ok)
+		}
+	}
+}
+
+func TestRegexPriorityLevel(t *testing.T) {
+	tests := []struct {
+		format *RegexFormat
+		data   string
+		level  string
+	}{
+		{FormatRFC3164, "<11>Oct 11 22:14:15 host app[42]: failed", LEVEL_ERROR},
+		{FormatRFC3164, "<165>Oct 11 22:14:15 host app: started", LEVEL_INFO},
+		{FormatRFC3164, "Oct 11 22:14:15 host app: no priority", "info"},
+		{FormatRFC5424, "<12>1 2022-10-11T22:14:15.003Z host app 42 ID47 - careful", LEVEL_WARN},
+		{FormatRFC5424, "<15>1 2022-10-11T22:14:15.003Z host app 42 ID47 [a b=\"c\"] noisy", LEVEL_DEBUG},
+	}
+
+	for _, test := range tests {
+		line, err := test.format.Decode(test.data)
+		if err != nil {
+			t.Errorf("%q: %s", test.data, err.Error())
+			continue
+		}
+
+		if got := line["level"]; got != test.level {
+			t.Errorf("%q: expected %q, got %q", test.data, test.level, got)
+		}
+
+		if _, ok := line["time"].(time.Time); !ok {
+			t.Errorf("%q: expected a decoded time, got %#v", test.data, line["time"])
+		}
+	}
+}
+
+func TestFillYear(t *testing.T) {
+	now := time.Date(2023, time.January, 2, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		stamp string
+		year  int
+	}{
+		{"Jan  2 09:00:00", 2023},
+		{"Jan  3 09:00:00", 2023},
+		{"Jan  3 11:00:00", 2022},
+		{"Dec 31 23:59:59", 2022},
+	}
+
+	for _, test := range tests {
+		ts, err := time.Parse("Jan _2 15:04:05", test.stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filled := fillYear(ts, now)
+		if filled.Year() != test.year {
+			t.Errorf("%q: expected %d, got %s", test.stamp, test.year, filled)
+		}
+
+		if filled.Month() != ts.Month() || filled.Day() != ts.Day() {
+			t.Errorf("%q: expected the same day, got %s", test.stamp, filled)
+		}
+	}
+}
+
+/* regex_test.go ends here. */
@@ -0,0 +1,279 @@
+/*
+ * renderer.go --- Output renderers.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	STYLE_PLAIN = iota
+	STYLE_KEY
+	STYLE_TIMESTAMP
+	STYLE_ERROR
+	STYLE_DIM
+	STYLE_TRACE_FUNCTION
+	STYLE_TRACE_FILE
+	STYLE_TRACE_LINE
+	STYLE_TRACE_PUNCT
+	STYLE_SOURCE
+	STYLE_SOURCE_HIGHLIGHT
+	STYLE_DIFF_LEFT
+	STYLE_DIFF_RIGHT
+	STYLE_MAX
+)
+
+type Style int
+
+var styles []string = []string{
+	STYLE_PLAIN:            "plain",
+	STYLE_KEY:              "key",
+	STYLE_TIMESTAMP:        "timestamp",
+	STYLE_ERROR:            "error",
+	STYLE_DIM:              "dim",
+	STYLE_TRACE_FUNCTION:   "trace-function",
+	STYLE_TRACE_FILE:       "trace-file",
+	STYLE_TRACE_LINE:       "trace-line",
+	STYLE_TRACE_PUNCT:      "trace-punct",
+	STYLE_SOURCE:           "source",
+	STYLE_SOURCE_HIGHLIGHT: "source-highlight",
+	STYLE_DIFF_LEFT:        "diff-left",
+	STYLE_DIFF_RIGHT:       "diff-right",
+}
+
+func (s Style) String() string {
+	return styles[s]
+}
+
+const (
+	COLOR_AUTO   string = "auto"
+	COLOR_ALWAYS string = "always"
+	COLOR_NEVER  string = "never"
+	COLOR_HTML   string = "html"
+)
+
+// A renderer decorates text for a particular kind of output.
+//
+// All text written by entities passes through a renderer, so that
+// renderers which need to escape their output can do so.
+type Renderer interface {
+	Name() string
+
+	// Decorate text in the given style.
+	Style(Style, string) string
+
+	// Decorate text using the colour for the given level.
+	Level(string, string) string
+
+	// Text to write before and after a rendered document.
+	Begin() string
+	End() string
+}
+
+// ==================================================================
+// {{{ Plain text:
+
+type PlainRenderer struct {
+}
+
+func (r *PlainRenderer) Name() string {
+	return "plain"
+}
+
+func (r *PlainRenderer) Style(style Style, text string) string {
+	return text
+}
+
+func (r *PlainRenderer) Level(level, text string) string {
+	return text
+}
+
+func (r *PlainRenderer) Begin() string {
+	return ""
+}
+
+func (r *PlainRenderer) End() string {
+	return ""
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ ANSI:
+
+type ANSIRenderer struct {
+	theme *Theme
+}
+
+func NewANSIRenderer(theme *Theme) *ANSIRenderer {
+	if theme == nil {
+		theme = DefaultTheme()
+	}
+
+	return &ANSIRenderer{
+		theme: theme,
+	}
+}
+
+func (r *ANSIRenderer) Name() string {
+	return "ansi"
+}
+
+func (r *ANSIRenderer) escape(code, text string) string {
+	if code == "" {
+		return text
+	}
+
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", code, text)
+}
+
+func (r *ANSIRenderer) Style(style Style, text string) string {
+	return r.escape(r.theme.Style(style), text)
+}
+
+func (r *ANSIRenderer) Level(level, text string) string {
+	return r.escape(r.theme.Level(level), text)
+}
+
+func (r *ANSIRenderer) Begin() string {
+	return ""
+}
+
+func (r *ANSIRenderer) End() string {
+	return ""
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ HTML:
+
+// Renders HTML fragments, with CSS classes named after styles and
+// levels, e.g. `gt-key` and `gt-level-info`.
+type HTMLRenderer struct {
+}
+
+func (r *HTMLRenderer) Name() string {
+	return "html"
+}
+
+func (r *HTMLRenderer) Style(style Style, text string) string {
+	if style == STYLE_PLAIN {
+		return html.EscapeString(text)
+	}
+
+	return fmt.Sprintf(
+		`<span class="gt-%s">%s</span>`,
+		style,
+		html.EscapeString(text),
+	)
+}
+
+func (r *HTMLRenderer) Level(level, text string) string {
+	return fmt.Sprintf(
+		`<span class="gt-level gt-level-%s">%s</span>`,
+		html.EscapeString(strings.ToLower(level)),
+		html.EscapeString(text),
+	)
+}
+
+func (r *HTMLRenderer) Begin() string {
+	return "<pre class=\"gotools\">\n"
+}
+
+func (r *HTMLRenderer) End() string {
+	return "</pre>\n"
+}
+
+// }}}
+// ==================================================================
+
+var (
+	rendererLock sync.RWMutex
+	renderer     Renderer = NewANSIRenderer(nil)
+)
+
+// Set the renderer used by all entities.
+func SetRenderer(r Renderer) {
+	rendererLock.Lock()
+	defer rendererLock.Unlock()
+
+	renderer = r
+}
+
+func GetRenderer() Renderer {
+	rendererLock.RLock()
+	defer rendererLock.RUnlock()
+
+	return renderer
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Select a renderer for the given colour mode, output file and theme.
+//
+// In "auto" mode, colour is used only when the output is a terminal and
+// the NO_COLOR environment variable is not set.
+func RendererForMode(mode string, out *os.File, theme *Theme) (Renderer, error) {
+	switch strings.ToLower(mode) {
+	case COLOR_ALWAYS:
+		return NewANSIRenderer(theme), nil
+
+	case COLOR_NEVER:
+		return &PlainRenderer{}, nil
+
+	case COLOR_HTML:
+		return &HTMLRenderer{}, nil
+
+	case COLOR_AUTO, "":
+		if os.Getenv("NO_COLOR") != "" {
+			return &PlainRenderer{}, nil
+		}
+
+		if out != nil && !isTerminal(out) {
+			return &PlainRenderer{}, nil
+		}
+
+		return NewANSIRenderer(theme), nil
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown colour mode '%s', must be one of: auto, always, never, html",
+		mode,
+	)
+}
+
+/* renderer.go ends here. */
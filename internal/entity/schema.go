@@ -0,0 +1,261 @@
+/*
+ * schema.go --- Log schema profiles.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FIELD_LEVEL      string = "level"
+	FIELD_TIME       string = "time"
+	FIELD_CALLER     string = "caller"
+	FIELD_MESSAGE    string = "message"
+	FIELD_STACKTRACE string = "stacktrace"
+
+	SCHEMA_AUTO string = "auto"
+
+	// Number of lines sampled when detecting a schema.
+	SCHEMA_SAMPLE_SIZE int = 20
+)
+
+// A schema maps the logical fields of a log entry to the key names
+// used by a particular logging library.
+//
+// Keys that are empty are not present in that library's output.
+type Schema struct {
+	Name       string
+	Level      string
+	Time       string
+	Caller     string
+	Message    string
+	Stacktrace string
+
+	// Keys that are not mapped to a logical field, but whose presence
+	// help distinguish this schema from others during detection.
+	Hints []string
+}
+
+var (
+	SchemaZapProduction = &Schema{
+		Name:       "zap",
+		Level:      "level",
+		Time:       "ts",
+		Caller:     "caller",
+		Message:    "msg",
+		Stacktrace: "stacktrace",
+	}
+
+	SchemaZapDevelopment = &Schema{
+		Name:       "zapdev",
+		Level:      "L",
+		Time:       "T",
+		Caller:     "C",
+		Message:    "M",
+		Stacktrace: "S",
+		Hints:      []string{"N"},
+	}
+
+	SchemaLogrus = &Schema{
+		Name:    "logrus",
+		Level:   "level",
+		Time:    "time",
+		Caller:  "file",
+		Message: "msg",
+		Hints:   []string{"func"},
+	}
+
+	SchemaSlog = &Schema{
+		Name:    "slog",
+		Level:   "level",
+		Time:    "time",
+		Caller:  "source",
+		Message: "msg",
+	}
+
+	SchemaBunyan = &Schema{
+		Name:    "bunyan",
+		Level:   "level",
+		Time:    "time",
+		Caller:  "src",
+		Message: "msg",
+		Hints:   []string{"v", "name", "hostname", "pid"},
+	}
+
+	SchemaPino = &Schema{
+		Name:    "pino",
+		Level:   "level",
+		Time:    "time",
+		Caller:  "caller",
+		Message: "msg",
+		Hints:   []string{"pid", "hostname"},
+	}
+
+	// Schemas in order of preference when detection is ambiguous.
+	schemas []*Schema = []*Schema{
+		SchemaZapProduction,
+		SchemaZapDevelopment,
+		SchemaLogrus,
+		SchemaSlog,
+		SchemaBunyan,
+		SchemaPino,
+	}
+)
+
+func DefaultSchema() *Schema {
+	return SchemaZapProduction
+}
+
+// Return the names of all known schemas, suitable for usage messages.
+func SchemaNames() []string {
+	names := make([]string, 0, len(schemas))
+
+	for idx := range schemas {
+		names = append(names, schemas[idx].Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func FindSchema(name string) (*Schema, error) {
+	lname := strings.ToLower(name)
+
+	for idx := range schemas {
+		if schemas[idx].Name == lname {
+			return schemas[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown schema '%s', must be one of: %s",
+		name,
+		strings.Join(SchemaNames(), ", "),
+	)
+}
+
+func (s *Schema) mapping() map[string]string {
+	return map[string]string{
+		FIELD_LEVEL:      s.Level,
+		FIELD_TIME:       s.Time,
+		FIELD_CALLER:     s.Caller,
+		FIELD_MESSAGE:    s.Message,
+		FIELD_STACKTRACE: s.Stacktrace,
+	}
+}
+
+// Return the key used by this schema for the given logical field.
+func (s *Schema) Key(field string) (string, bool) {
+	key, ok := s.mapping()[field]
+	if !ok || key == "" {
+		return "", false
+	}
+
+	return key, true
+}
+
+// Return the logical field for the given key, if this schema maps it.
+func (s *Schema) Field(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	for field, mapped := range s.mapping() {
+		if mapped == key {
+			return field, true
+		}
+	}
+
+	return "", false
+}
+
+func (s *Schema) score(line Line) int {
+	score := 0
+
+	for _, key := range s.mapping() {
+		if key == "" {
+			continue
+		}
+
+		if _, ok := line[key]; ok {
+			score += 2
+		} else {
+			score -= 1
+		}
+	}
+
+	for idx := range s.Hints {
+		if _, ok := line[s.Hints[idx]]; ok {
+			score++
+		}
+	}
+
+	return score
+}
+
+// Detect the schema used by a log by sampling its lines.
+//
+// Formats that provide their own schema always use it.  Otherwise,
+// lines that cannot be decoded with the given format are ignored.  If
+// nothing can be detected, the default schema is returned.
+func DetectSchema(lines []string, format Format) *Schema {
+	if provider, ok := format.(SchemaProvider); ok {
+		return provider.Schema()
+	}
+
+	scores := make([]int, len(schemas))
+	sampled := 0
+
+	for idx := range lines {
+		if sampled == SCHEMA_SAMPLE_SIZE {
+			break
+		}
+
+		line, err := format.Decode(lines[idx])
+		if err != nil {
+			continue
+		}
+
+		for sidx := range schemas {
+			scores[sidx] += schemas[sidx].score(line)
+		}
+		sampled++
+	}
+
+	if sampled == 0 {
+		return DefaultSchema()
+	}
+
+	best := 0
+	for idx := range scores {
+		if scores[idx] > scores[best] {
+			best = idx
+		}
+	}
+
+	return schemas[best]
+}
+
+/* schema.go ends here. */
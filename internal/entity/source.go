@@ -0,0 +1,320 @@
+/*
+ * source.go --- Source snippets for stack frames.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	SOURCE_CONTEXT_DEFAULT int = 2
+)
+
+// A local source root.
+//
+// Files whose path begins with `Prefix` are looked for under `Path`
+// with the prefix replaced.  A root without a prefix is searched for
+// successively shorter tails of the file's path, so that build paths
+// such as `/go/src/github.com/a/b/main.go` are found as
+// `<Path>/github.com/a/b/main.go`.  Longer tails are preferred, and a
+// file is matched by its name alone only if exactly one file under the
+// roots has that name.
+type SourceRoot struct {
+	Prefix string `json:"prefix"`
+	Path   string `json:"path"`
+}
+
+// A line of source around a stack frame.
+type SourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+var (
+	// Version suffix of a module cache directory, e.g. `b@v1.2.3`.
+	moduleVersionRx = regexp.MustCompile(`@v[^/]+`)
+
+	sourceLock    sync.RWMutex
+	sourceRoots   []SourceRoot        = []SourceRoot{}
+	sourceContext int                 = SOURCE_CONTEXT_DEFAULT
+	sourceCache   map[string][]string = map[string][]string{}
+
+	// Files under the roots without a prefix, by name.  Built when
+	// first needed.
+	basenameLock sync.Mutex
+	basenames    map[string][]string = nil
+)
+
+// Set the source roots used to resolve stack frames.
+//
+// Snippets are shown only when at least one root is set.
+func SetSourceRoots(roots []SourceRoot) {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+
+	sourceRoots = roots
+	sourceCache = map[string][]string{}
+
+	basenameLock.Lock()
+	basenames = nil
+	basenameLock.Unlock()
+}
+
+// Parse a source root of the form `path` or `prefix=path`.
+func ParseSourceRoot(spec string) SourceRoot {
+	if pos := strings.Index(spec, "="); pos > -1 {
+		return SourceRoot{Prefix: spec[:pos], Path: spec[pos+1:]}
+	}
+
+	return SourceRoot{Path: spec}
+}
+
+// Set the number of lines shown either side of a frame's line.
+func SetSourceContext(lines int) {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+
+	if lines < 0 {
+		lines = 0
+	}
+
+	sourceContext = lines
+}
+
+func haveSourceRoots() bool {
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+
+	return len(sourceRoots) > 0
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return info.Mode().IsRegular()
+}
+
+// Return the only file under the roots without a prefix that has the
+// given name.  Must be called with `sourceLock` held.
+func uniqueBasename(name string) (string, bool) {
+	basenameLock.Lock()
+	defer basenameLock.Unlock()
+
+	if basenames == nil {
+		basenames = map[string][]string{}
+
+		for _, root := range sourceRoots {
+			if root.Prefix != "" {
+				continue
+			}
+
+			filepath.WalkDir(root.Path, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+
+				if entry.IsDir() {
+					if path != root.Path && strings.HasPrefix(entry.Name(), ".") {
+						return filepath.SkipDir
+					}
+
+					return nil
+				}
+
+				if entry.Type().IsRegular() {
+					basenames[entry.Name()] = append(basenames[entry.Name()], path)
+				}
+
+				return nil
+			})
+		}
+	}
+
+	if paths := basenames[name]; len(paths) == 1 {
+		return paths[0], true
+	}
+
+	return "", false
+}
+
+// Find the local copy of a file named in a stack frame.
+func ResolveSource(file string) (string, bool) {
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+
+	if file == "" {
+		return "", false
+	}
+
+	slashed := filepath.ToSlash(file)
+
+	for _, root := range sourceRoots {
+		if root.Prefix != "" {
+			if !strings.HasPrefix(slashed, root.Prefix) {
+				continue
+			}
+
+			rel := strings.TrimPrefix(slashed, root.Prefix)
+			path := filepath.Join(root.Path, filepath.FromSlash(rel))
+			if isFile(path) {
+				return path, true
+			}
+
+			continue
+		}
+
+		// Try the path with and without module cache versions.
+		for _, name := range []string{slashed, moduleVersionRx.ReplaceAllString(slashed, "")} {
+			parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+
+			for idx := 0; idx < len(parts)-1; idx++ {
+				path := filepath.Join(root.Path, filepath.Join(parts[idx:]...))
+				if isFile(path) {
+					return path, true
+				}
+			}
+		}
+	}
+
+	return uniqueBasename(filepath.Base(slashed))
+}
+
+func readSource(path string) ([]string, error) {
+	sourceLock.RLock()
+	lines, ok := sourceCache[path]
+	sourceLock.RUnlock()
+
+	if ok {
+		return lines, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines = []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sourceLock.Lock()
+	sourceCache[path] = lines
+	sourceLock.Unlock()
+
+	return lines, nil
+}
+
+// Return the lines of source around the frame, or nil if the source
+// cannot be found.
+func (stl StacktraceLine) Source() []SourceLine {
+	if stl.Line <= 0 {
+		return nil
+	}
+
+	path, ok := ResolveSource(stl.File)
+	if !ok {
+		return nil
+	}
+
+	lines, err := readSource(path)
+	if err != nil || stl.Line > len(lines) {
+		return nil
+	}
+
+	sourceLock.RLock()
+	context := sourceContext
+	sourceLock.RUnlock()
+
+	start := stl.Line - context
+	if start < 1 {
+		start = 1
+	}
+
+	end := stl.Line + context
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	snippet := make([]SourceLine, 0, end-start+1)
+	for num := start; num <= end; num++ {
+		snippet = append(snippet, SourceLine{
+			Number:  num,
+			Text:    lines[num-1],
+			Current: num == stl.Line,
+		})
+	}
+
+	return snippet
+}
+
+func (stl StacktraceLine) displaySource(w io.Writer) {
+	if !haveSourceRoots() {
+		return
+	}
+
+	snippet := stl.Source()
+	if len(snippet) == 0 {
+		return
+	}
+
+	r := GetRenderer()
+	width := len(fmt.Sprintf("%d", snippet[len(snippet)-1].Number))
+
+	for _, line := range snippet {
+		style := Style(STYLE_SOURCE)
+		marker := " "
+
+		if line.Current {
+			style = STYLE_SOURCE_HIGHLIGHT
+			marker = ">"
+		}
+
+		fmt.Fprintf(
+			w,
+			"  %s %s\n",
+			r.Style(STYLE_TRACE_PUNCT, fmt.Sprintf("%s%*d |", marker, width, line.Number)),
+			r.Style(style, strings.ReplaceAll(line.Text, "\t", "    ")),
+		)
+	}
+}
+
+/* source.go ends here. */
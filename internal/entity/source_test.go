@@ -0,0 +1,103 @@
+/*
+ * source_test.go --- Source resolution tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSource(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveSourceTail(t *testing.T) {
+	root := t.TempDir()
+	want := filepath.Join(root, "github.com", "a", "b", "main.go")
+	writeSource(t, want)
+
+	SetSourceRoots([]SourceRoot{{Path: root}})
+	defer SetSourceRoots([]SourceRoot{})
+
+	path, ok := ResolveSource("/go/src/github.com/a/b/main.go")
+	if !ok || path != want {
+		t.Errorf("Expected %q, got %q", want, path)
+	}
+}
+
+func TestResolveSourceBasename(t *testing.T) {
+	root := t.TempDir()
+	want := filepath.Join(root, "main.go")
+	writeSource(t, want)
+
+	SetSourceRoots([]SourceRoot{{Path: root}})
+	defer SetSourceRoots([]SourceRoot{})
+
+	for _, file := range []string{"main.go", "/build/github.com/a/b/main.go"} {
+		if path, ok := ResolveSource(file); !ok || path != want {
+			t.Errorf("%s: expected %q, got %q", file, want, path)
+		}
+	}
+}
+
+func TestResolveSourceBasenameCollision(t *testing.T) {
+	root := t.TempDir()
+	writeSource(t, filepath.Join(root, "main.go"))
+	writeSource(t, filepath.Join(root, "other", "main.go"))
+
+	SetSourceRoots([]SourceRoot{{Path: root}})
+	defer SetSourceRoots([]SourceRoot{})
+
+	// The name alone matches two files.
+	if path, ok := ResolveSource("/build/github.com/a/b/main.go"); ok {
+		t.Errorf("Expected no match, got %q", path)
+	}
+
+	// A longer tail picks one of them.
+	if path, ok := ResolveSource("/build/cmd/other/main.go"); !ok || path != filepath.Join(root, "other", "main.go") {
+		t.Errorf("Expected a match for other/main.go, got %q", path)
+	}
+}
+
+func TestResolveSourcePrefix(t *testing.T) {
+	root := t.TempDir()
+	want := filepath.Join(root, "pkg", "main.go")
+	writeSource(t, want)
+
+	SetSourceRoots([]SourceRoot{{Prefix: "/build/", Path: root}})
+	defer SetSourceRoots([]SourceRoot{})
+
+	path, ok := ResolveSource("/build/pkg/main.go")
+	if !ok || path != want {
+		t.Errorf("Expected %q, got %q", want, path)
+	}
+}
+
+/* source_test.go ends here. */
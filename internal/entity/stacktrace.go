@@ -26,18 +26,67 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
-type StacktraceLine map[string]string
+const (
+	TRACE_UNKNOWN string = ""
+	TRACE_GO      string = "go"
+	TRACE_JAVA    string = "java"
+	TRACE_PYTHON  string = "python"
+)
+
+// A single frame of a stack trace.
+//
+// `Function` is the function name without its package, e.g.
+// `(*Server).Serve`.  `Offset` is the program counter offset given in
+// Go traces, e.g. `+0x1a`.
+type StacktraceLine struct {
+	Package   string
+	Function  string
+	Args      string
+	File      string
+	Line      int
+	Offset    string
+	CreatedBy bool
+}
+
+// Return the fully-qualified function name.
+func (stl StacktraceLine) Name() string {
+	switch {
+	case stl.Package == "":
+		return stl.Function
+
+	case stl.Function == "":
+		return stl.Package
+	}
+
+	return stl.Package + "." + stl.Function
+}
 
 func (stl StacktraceLine) DisplayTo(w io.Writer) {
+	r := GetRenderer()
+	name := stl.Name()
+
+	if stl.CreatedBy {
+		name = "created by " + name
+	}
+
+	line := ""
+	if stl.Line > 0 {
+		line = strconv.Itoa(stl.Line)
+	}
+
 	fmt.Fprintf(
 		w,
-		"\x1b[0;33m%s\x1b[0m \x1b[1;36m->\x1b[0m \x1b[4;34m%s\x1b[0m \x1b[1;36m[\x1b[0m%s\x1b[1;36m]\x1b[0m\n",
-		stl["function"],
-		stl["file"],
-		stl["line"],
+		"%s %s %s %s%s%s\n",
+		r.Style(STYLE_TRACE_FUNCTION, name),
+		r.Style(STYLE_TRACE_PUNCT, "->"),
+		r.Style(STYLE_TRACE_FILE, stl.File),
+		r.Style(STYLE_TRACE_PUNCT, "["),
+		r.Style(STYLE_TRACE_LINE, line),
+		r.Style(STYLE_TRACE_PUNCT, "]"),
 	)
 }
 
@@ -45,51 +94,132 @@ func (stl StacktraceLine) Display() {
 	stl.DisplayTo(os.Stdout)
 }
 
-type Stacktrace []StacktraceLine
+// A thread of execution within a trace, such as a goroutine or a Java
+// exception in a chain of causes.
+//
+// Frames are ordered with the most recent call first.
+type StacktraceThread struct {
+	ID     int
+	Name   string
+	State  string
+	Frames []StacktraceLine
+}
+
+func (th StacktraceThread) header() string {
+	switch {
+	case th.Name != "" && th.State != "":
+		return fmt.Sprintf("%s [%s]", th.Name, th.State)
 
-func (st Stacktrace) DisplayTo(w io.Writer) {
-	if len(st) == 0 {
-		return
+	case th.Name != "":
+		return th.Name
 	}
 
-	for idx := range st {
-		st[idx].DisplayTo(w)
-	}
+	return ""
 }
 
-func (st Stacktrace) Display() {
-	st.DisplayTo(os.Stdout)
+type Stacktrace struct {
+	Kind    string
+	Message string
+	Threads []StacktraceThread
 }
 
-func NewStacktraceFromString(trace string) Stacktrace {
-	fields := strings.Fields(trace)
+// Return the total number of frames in the trace.
+func (st Stacktrace) Len() int {
+	count := 0
 
-	var traces Stacktrace = Stacktrace{}
-	var line StacktraceLine
+	for idx := range st.Threads {
+		count += len(st.Threads[idx].Frames)
+	}
 
-	count := len(fields)
-	idx := 0
+	return count
+}
 
-	for {
-		if idx == count {
-			break
+// Does any frame in the trace name a file and line?
+func (st Stacktrace) Located() bool {
+	for idx := range st.Threads {
+		for _, frame := range st.Threads[idx].Frames {
+			if frame.File != "" && frame.Line > 0 {
+				return true
+			}
 		}
+	}
+
+	return false
+}
+
+// Return the frames of the first thread, which is the one that failed.
+func (st Stacktrace) Frames() []StacktraceLine {
+	if len(st.Threads) == 0 {
+		return []StacktraceLine{}
+	}
 
-		line = StacktraceLine{}
-		line["function"] = fields[idx]
+	return st.Threads[0].Frames
+}
+
+// Return the trace as text, one frame per line.
+func (st Stacktrace) String() string {
+	lines := []string{}
+
+	if st.Message != "" {
+		lines = append(lines, st.Message)
+	}
+
+	for _, frame := range st.Frames() {
+		lines = append(lines, fmt.Sprintf("%s %s:%d", frame.Name(), frame.File, frame.Line))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (st Stacktrace) DisplayTo(w io.Writer) {
+	if st.Len() == 0 && st.Message == "" {
+		return
+	}
+
+	r := GetRenderer()
+
+	if st.Message != "" {
+		fmt.Fprintf(w, "%s\n", r.Style(STYLE_ERROR, st.Message))
+	}
+
+	for tidx := range st.Threads {
+		thread := st.Threads[tidx]
 
-		if pos := strings.Index(fields[idx+1], ":"); pos > -1 {
-			parts := strings.Split(fields[idx+1], ":")
-			line["file"] = parts[0]
-			line["line"] = parts[1]
-			idx++
+		if header := thread.header(); header != "" {
+			if tidx > 0 || st.Message != "" {
+				fmt.Fprintf(w, "\n")
+			}
+
+			fmt.Fprintf(w, "%s\n", r.Style(STYLE_KEY, header+":"))
 		}
 
-		traces = append(traces, line)
-		idx++
+		for idx := range thread.Frames {
+			thread.Frames[idx].DisplayTo(w)
+			thread.Frames[idx].displaySource(w)
+		}
+	}
+}
+
+func (st Stacktrace) Display() {
+	st.DisplayTo(os.Stdout)
+}
+
+// Parse a stack trace, detecting whether it is from Go, Java or Python.
+//
+// This never fails; text that is not recognised yields a trace with no
+// frames.
+func NewStacktraceFromString(trace string) Stacktrace {
+	lines := splitTraceLines(trace)
+
+	switch {
+	case isPythonTrace(lines):
+		return parsePythonTrace(lines)
+
+	case isJavaTrace(lines):
+		return parseJavaTrace(lines)
 	}
 
-	return traces
+	return parseGoTrace(lines)
 }
 
 /* stacktrace.go ends here. */
@@ -0,0 +1,264 @@
+/*
+ * theme.go --- Colour themes.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	THEME_DEFAULT string = "dark"
+)
+
+// A theme maps styles and levels to ANSI SGR parameters such as "1;36",
+// and names the colours used for selections in the viewer.
+//
+// A theme may name a base theme, from which any missing entries are
+// taken.  Themes without a base inherit from the default theme, and a
+// theme named after an existing one inherits from the theme it
+// replaces.
+type Theme struct {
+	Name        string            `json:"name"`
+	Base        string            `json:"base"`
+	Styles      map[string]string `json:"styles"`
+	Levels      map[string]string `json:"levels"`
+	SelectionFg string            `json:"selection_fg"`
+	SelectionBg string            `json:"selection_bg"`
+}
+
+var (
+	ThemeDark = &Theme{
+		Name: "dark",
+		Styles: map[string]string{
+			"key":              "1;36",
+			"timestamp":        "1;36",
+			"error":            "0;31",
+			"dim":              "2",
+			"trace-function":   "0;33",
+			"trace-file":       "4;34",
+			"trace-line":       "",
+			"trace-punct":      "1;36",
+			"source":           "2",
+			"source-highlight": "1;33;41",
+			"diff-left":        "0;31",
+			"diff-right":       "0;32",
+		},
+		Levels: map[string]string{
+			"TRACE":  "0;37",
+			"DEBUG":  "1;33",
+			"INFO":   "1;32",
+			"WARN":   "0;31",
+			"ERROR":  "1;31",
+			"DPANIC": "1;35",
+			"PANIC":  "1;37;45",
+			"FATAL":  "1;37;41",
+		},
+		SelectionFg: "white",
+		SelectionBg: "blue",
+	}
+
+	ThemeLight = &Theme{
+		Name: "light",
+		Styles: map[string]string{
+			"key":              "1;34",
+			"timestamp":        "0;34",
+			"error":            "1;31",
+			"dim":              "2",
+			"trace-function":   "0;35",
+			"trace-file":       "4;34",
+			"trace-line":       "1",
+			"trace-punct":      "0;34",
+			"source":           "2",
+			"source-highlight": "1;37;41",
+			"diff-left":        "0;31",
+			"diff-right":       "0;32",
+		},
+		Levels: map[string]string{
+			"TRACE":  "0;90",
+			"DEBUG":  "0;35",
+			"INFO":   "0;32",
+			"WARN":   "1;30;43",
+			"ERROR":  "1;31",
+			"DPANIC": "1;37;45",
+			"PANIC":  "1;37;45",
+			"FATAL":  "1;37;41",
+		},
+		SelectionFg: "black",
+		SelectionBg: "cyan",
+	}
+
+	ThemeHighContrast = &Theme{
+		Name: "high-contrast",
+		Styles: map[string]string{
+			"key":              "1;4",
+			"timestamp":        "1",
+			"error":            "1;37;41",
+			"dim":              "7",
+			"trace-function":   "1",
+			"trace-file":       "4",
+			"trace-line":       "1",
+			"trace-punct":      "1",
+			"source":           "",
+			"source-highlight": "7",
+			"diff-left":        "1;37;41",
+			"diff-right":       "1;30;42",
+		},
+		Levels: map[string]string{
+			"TRACE":  "7",
+			"DEBUG":  "1;30;47",
+			"INFO":   "1;30;42",
+			"WARN":   "1;30;43",
+			"ERROR":  "1;37;41",
+			"DPANIC": "1;37;45",
+			"PANIC":  "1;37;45",
+			"FATAL":  "1;5;37;41",
+		},
+		SelectionFg: "black",
+		SelectionBg: "white",
+	}
+
+	themes []*Theme = []*Theme{
+		ThemeDark,
+		ThemeLight,
+		ThemeHighContrast,
+	}
+)
+
+func DefaultTheme() *Theme {
+	return ThemeDark
+}
+
+// Return the names of all known themes, suitable for usage messages.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+
+	for idx := range themes {
+		names = append(names, themes[idx].Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func FindTheme(name string) (*Theme, error) {
+	lname := strings.ToLower(name)
+
+	for idx := range themes {
+		if themes[idx].Name == lname {
+			return themes[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown theme '%s', must be one of: %s",
+		name,
+		strings.Join(ThemeNames(), ", "),
+	)
+}
+
+// Fill in missing entries from the theme's base.
+func (t *Theme) inherit() error {
+	basename := t.Base
+	if basename == "" {
+		basename = THEME_DEFAULT
+		if _, err := FindTheme(t.Name); err == nil {
+			basename = t.Name
+		}
+	}
+
+	base, err := FindTheme(basename)
+	if err != nil {
+		return fmt.Errorf("Theme '%s': %s", t.Name, err.Error())
+	}
+
+	if base == t {
+		return nil
+	}
+
+	if t.Styles == nil {
+		t.Styles = map[string]string{}
+	}
+
+	if t.Levels == nil {
+		t.Levels = map[string]string{}
+	}
+
+	for k, v := range base.Styles {
+		if _, ok := t.Styles[k]; !ok {
+			t.Styles[k] = v
+		}
+	}
+
+	for k, v := range base.Levels {
+		if _, ok := t.Levels[k]; !ok {
+			t.Levels[k] = v
+		}
+	}
+
+	if t.SelectionFg == "" {
+		t.SelectionFg = base.SelectionFg
+	}
+
+	if t.SelectionBg == "" {
+		t.SelectionBg = base.SelectionBg
+	}
+
+	return nil
+}
+
+// Register a theme, replacing any existing theme of the same name.
+func RegisterTheme(theme *Theme) error {
+	if theme.Name == "" {
+		return fmt.Errorf("Theme has no name")
+	}
+
+	theme.Name = strings.ToLower(theme.Name)
+	if err := theme.inherit(); err != nil {
+		return err
+	}
+
+	for idx := range themes {
+		if themes[idx].Name == theme.Name {
+			themes[idx] = theme
+			return nil
+		}
+	}
+
+	themes = append(themes, theme)
+
+	return nil
+}
+
+// Return the SGR parameters for the given style.
+func (t *Theme) Style(style Style) string {
+	return t.Styles[style.String()]
+}
+
+// Return the SGR parameters for the given level.
+func (t *Theme) Level(level string) string {
+	return t.Levels[strings.ToUpper(level)]
+}
+
+/* theme.go ends here. */
@@ -0,0 +1,224 @@
+/*
+ * timefmt.go --- Timestamp display.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TIMEFMT_RFC1123     string = "rfc1123"
+	TIMEFMT_RFC3339     string = "rfc3339"
+	TIMEFMT_RFC3339MS   string = "rfc3339ms"
+	TIMEFMT_RFC3339NANO string = "rfc3339nano"
+	TIMEFMT_RELATIVE    string = "relative"
+
+	TIMEZONE_LOCAL string = "Local"
+	TIMEZONE_UTC   string = "UTC"
+)
+
+var timeFormats map[string]string = map[string]string{
+	TIMEFMT_RFC1123:     time.RFC1123,
+	TIMEFMT_RFC3339:     time.RFC3339,
+	TIMEFMT_RFC3339MS:   "2006-01-02T15:04:05.000Z07:00",
+	TIMEFMT_RFC3339NANO: time.RFC3339Nano,
+}
+
+var (
+	timeLock        sync.RWMutex
+	displayZone     *time.Location = nil
+	displayLayout   string         = time.RFC1123
+	displayRelative bool           = false
+)
+
+// Return the names of the time formats, suitable for usage messages.
+func TimeFormatNames() []string {
+	return []string{
+		TIMEFMT_RFC1123,
+		TIMEFMT_RFC3339,
+		TIMEFMT_RFC3339MS,
+		TIMEFMT_RFC3339NANO,
+		TIMEFMT_RELATIVE,
+	}
+}
+
+// Set the zone times are shown in, such as `UTC`, `Local` or
+// `Europe/London`.  An empty name shows times in the zone they were
+// decoded in.
+func SetTimeZone(name string) error {
+	var loc *time.Location = nil
+
+	switch strings.ToLower(name) {
+	case "":
+
+	case "local":
+		loc = time.Local
+
+	case "utc", "z":
+		loc = time.UTC
+
+	default:
+		var err error
+
+		if loc, err = time.LoadLocation(name); err != nil {
+			return fmt.Errorf("Unknown time zone '%s'", name)
+		}
+	}
+
+	timeLock.Lock()
+	defer timeLock.Unlock()
+
+	displayZone = loc
+
+	return nil
+}
+
+// Set the format times are shown in.
+//
+// This is one of the named formats, or a Go time layout such as
+// `15:04:05.000`.  Relative times, such as `3m ago`, are shown as
+// RFC3339 with milliseconds where an absolute time is needed.
+func SetTimeFormat(spec string) error {
+	layout, relative := "", false
+
+	if spec == "" {
+		spec = TIMEFMT_RFC1123
+	}
+
+	if named, ok := timeFormats[strings.ToLower(spec)]; ok {
+		layout = named
+	} else if strings.ToLower(spec) == TIMEFMT_RELATIVE {
+		layout, relative = timeFormats[TIMEFMT_RFC3339MS], true
+	} else {
+		// A layout must contain at least one element of the reference time.
+		if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(spec) == spec {
+			return fmt.Errorf(
+				"Unknown time format '%s', must be one of: %s, or a layout",
+				spec,
+				strings.Join(TimeFormatNames(), ", "),
+			)
+		}
+
+		layout = spec
+	}
+
+	timeLock.Lock()
+	defer timeLock.Unlock()
+
+	displayLayout = layout
+	displayRelative = relative
+
+	return nil
+}
+
+// Return the time in the display zone.
+func DisplayTime(ts time.Time) time.Time {
+	timeLock.RLock()
+	defer timeLock.RUnlock()
+
+	if displayZone == nil {
+		return ts
+	}
+
+	return ts.In(displayZone)
+}
+
+// Return the name of the zone the given time is shown in.  Without a
+// display zone, this is the zone the time was decoded in.
+func DisplayZone(ts time.Time) string {
+	shown := DisplayTime(ts)
+
+	if name := shown.Location().String(); name != "" {
+		return name
+	}
+
+	return shown.Format("MST")
+}
+
+// Format a time in the display zone and format.
+func FormatTime(ts time.Time) string {
+	timeLock.RLock()
+	relative := displayRelative
+	timeLock.RUnlock()
+
+	if relative {
+		return RelativeTime(ts, time.Now())
+	}
+
+	return FormatAbsoluteTime(ts)
+}
+
+// Format a time in the display zone, using RFC3339 with milliseconds
+// if the display format is relative.
+func FormatAbsoluteTime(ts time.Time) string {
+	return formatLayout(DisplayTime(ts))
+}
+
+// Format a time with the display layout, in its own zone.
+func formatLayout(ts time.Time) string {
+	timeLock.RLock()
+	layout := displayLayout
+	timeLock.RUnlock()
+
+	return ts.Format(layout)
+}
+
+// Describe a time relative to another, as in `3m ago` or `in 2h`.
+func RelativeTime(ts, now time.Time) string {
+	diff := now.Sub(ts)
+	future := diff < 0
+
+	if future {
+		diff = -diff
+	}
+
+	var text string
+
+	switch {
+	case diff < time.Second:
+		return "just now"
+
+	case diff < time.Minute:
+		text = fmt.Sprintf("%ds", int(diff/time.Second))
+
+	case diff < time.Hour:
+		text = fmt.Sprintf("%dm", int(diff/time.Minute))
+
+	case diff < 24*time.Hour:
+		text = fmt.Sprintf("%dh", int(diff/time.Hour))
+
+	default:
+		text = fmt.Sprintf("%dd", int(diff/(24*time.Hour)))
+	}
+
+	if future {
+		return "in " + text
+	}
+
+	return text + " ago"
+}
+
+/* timefmt.go ends here. */
@@ -0,0 +1,186 @@
+/*
+ * timestamp.go --- Timestamp decoding.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TIME_UNKNOWN = iota
+	TIME_EPOCH_SECONDS
+	TIME_EPOCH_MILLIS
+	TIME_EPOCH_MICROS
+	TIME_EPOCH_NANOS
+	TIME_RFC3339
+	TIME_ISO8601
+	TIME_LAYOUT
+)
+
+type TimeEncoding int
+
+var timeEncodings []string = []string{
+	TIME_UNKNOWN:       "Unknown",
+	TIME_EPOCH_SECONDS: "Epoch seconds",
+	TIME_EPOCH_MILLIS:  "Epoch milliseconds",
+	TIME_EPOCH_MICROS:  "Epoch microseconds",
+	TIME_EPOCH_NANOS:   "Epoch nanoseconds",
+	TIME_RFC3339:       "RFC3339",
+	TIME_ISO8601:       "ISO8601",
+	TIME_LAYOUT:        "Layout",
+}
+
+func (te TimeEncoding) String() string {
+	return timeEncodings[te]
+}
+
+// Upper bounds used to tell epoch units apart.  A value of less than
+// 1e11 seconds takes us to the year 5138, so anything larger must be in
+// a finer unit.
+const (
+	epochMaxSeconds float64 = 1e11
+	epochMaxMillis  float64 = 1e14
+	epochMaxMicros  float64 = 1e17
+
+	// Nanoseconds beyond this overflow an int64.
+	epochMaxNanos float64 = 9.2e18
+)
+
+type timeLayout struct {
+	layout   string
+	encoding TimeEncoding
+}
+
+// Layouts tried, in order, against string timestamps.
+var timeLayouts []timeLayout = []timeLayout{
+	{time.RFC3339Nano, TIME_RFC3339},
+	{"2006-01-02T15:04:05.000Z0700", TIME_ISO8601},
+	{"2006-01-02T15:04:05Z0700", TIME_ISO8601},
+	{"2006-01-02T15:04:05.999999999", TIME_ISO8601},
+	{"2006-01-02 15:04:05.999999999Z07:00", TIME_ISO8601},
+	{"2006-01-02 15:04:05.999999999Z0700", TIME_ISO8601},
+	{"2006-01-02 15:04:05.999999999", TIME_ISO8601},
+	{time.RFC1123Z, TIME_LAYOUT},
+	{time.RFC1123, TIME_LAYOUT},
+	{time.UnixDate, TIME_LAYOUT},
+}
+
+func decodeEpoch(val float64) (time.Time, TimeEncoding, error) {
+	abs := math.Abs(val)
+
+	switch {
+	case math.IsNaN(val) || abs >= epochMaxNanos:
+		return time.Time{}, TIME_UNKNOWN, fmt.Errorf("Timestamp %g is out of range", val)
+
+	case abs < epochMaxSeconds:
+		return FloatToTime(val), TIME_EPOCH_SECONDS, nil
+
+	case abs < epochMaxMillis:
+		return time.UnixMilli(int64(val)), TIME_EPOCH_MILLIS, nil
+
+	case abs < epochMaxMicros:
+		return time.UnixMicro(int64(val)), TIME_EPOCH_MICROS, nil
+	}
+
+	return time.Unix(0, int64(val)), TIME_EPOCH_NANOS, nil
+}
+
+// Integers are decoded exactly, as nanoseconds since the epoch are too
+// large for a float64 to hold.
+func decodeEpochInt(val int64) (time.Time, TimeEncoding, error) {
+	// Compared as integers, as a float64 rounds values just below a
+	// bound up to it.
+	abs := val
+	if abs < 0 {
+		abs = -abs
+	}
+
+	switch {
+	case abs >= 0 && abs < int64(epochMaxSeconds):
+		return time.Unix(val, 0), TIME_EPOCH_SECONDS, nil
+
+	case abs >= 0 && abs < int64(epochMaxMillis):
+		return time.UnixMilli(val), TIME_EPOCH_MILLIS, nil
+
+	case abs >= 0 && abs < int64(epochMaxMicros):
+		return time.UnixMicro(val), TIME_EPOCH_MICROS, nil
+	}
+
+	return time.Unix(0, val), TIME_EPOCH_NANOS, nil
+}
+
+func decodeTimeString(val string) (time.Time, TimeEncoding, error) {
+	str := strings.TrimSpace(val)
+
+	if num, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return decodeEpochInt(num)
+	}
+
+	if num, err := strconv.ParseFloat(str, 64); err == nil {
+		return decodeEpoch(num)
+	}
+
+	for idx := range timeLayouts {
+		if ts, err := time.Parse(timeLayouts[idx].layout, str); err == nil {
+			return ts, timeLayouts[idx].encoding, nil
+		}
+	}
+
+	return time.Time{}, TIME_UNKNOWN, fmt.Errorf("Unrecognised timestamp '%s'", val)
+}
+
+// Decode a timestamp value as found in a decoded log line.
+//
+// Numbers are treated as offsets from the Unix epoch, with the unit
+// guessed from the magnitude.  Strings are tried against RFC3339, the
+// ISO8601 variants written by zap, and a few common layouts.
+func DecodeTime(value interface{}) (time.Time, TimeEncoding, error) {
+	switch val := value.(type) {
+	case time.Time:
+		return val, TIME_LAYOUT, nil
+
+	case float64:
+		return decodeEpoch(val)
+
+	case int64:
+		return decodeEpochInt(val)
+
+	case int:
+		return decodeEpochInt(int64(val))
+
+	case json.Number:
+		return decodeTimeString(val.String())
+
+	case string:
+		return decodeTimeString(val)
+	}
+
+	return time.Time{}, TIME_UNKNOWN, fmt.Errorf("Unsupported timestamp type %T", value)
+}
+
+/* timestamp.go ends here. */
@@ -0,0 +1,192 @@
+/*
+ * timestamp_test.go --- Timestamp decoding tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecodeEpoch(t *testing.T) {
+	tests := []struct {
+		val      float64
+		want     time.Time
+		encoding TimeEncoding
+	}{
+		{0, time.Unix(0, 0), TIME_EPOCH_SECONDS},
+		{1650000000.5, time.Unix(1650000000, 500000000), TIME_EPOCH_SECONDS},
+		{99999999999, time.Unix(99999999999, 0), TIME_EPOCH_SECONDS},
+		{1e11, time.UnixMilli(1e11), TIME_EPOCH_MILLIS},
+		{1650000000506, time.UnixMilli(1650000000506), TIME_EPOCH_MILLIS},
+		{99999999999999, time.UnixMilli(99999999999999), TIME_EPOCH_MILLIS},
+		{1e14, time.UnixMicro(1e14), TIME_EPOCH_MICROS},
+		{9.9e16, time.UnixMicro(9.9e16), TIME_EPOCH_MICROS},
+		{1e17, time.Unix(0, 1e17), TIME_EPOCH_NANOS},
+		{1.65e18, time.Unix(0, 1.65e18), TIME_EPOCH_NANOS},
+		{-1e11, time.UnixMilli(-1e11), TIME_EPOCH_MILLIS},
+	}
+
+	for _, test := range tests {
+		ts, enc, err := decodeEpoch(test.val)
+		if err != nil {
+			t.Errorf("%g: %s", test.val, err.Error())
+			continue
+		}
+
+		if enc != test.encoding {
+			t.Errorf("%g: expected %s, got %s", test.val, test.encoding, enc)
+		}
+
+		if !ts.Equal(test.want) {
+			t.Errorf("%g: expected %s, got %s", test.val, test.want, ts)
+		}
+	}
+}
+
+func TestDecodeEpochOutOfRange(t *testing.T) {
+	tests := []float64{
+		math.NaN(),
+		math.Inf(1),
+		math.Inf(-1),
+		9.2e18,
+		-9.2e18,
+		1e30,
+	}
+
+	for _, val := range tests {
+		if ts, _, err := decodeEpoch(val); err == nil {
+			t.Errorf("%g: expected an error, got %s", val, ts)
+		}
+	}
+}
+
+func TestDecodeEpochInt(t *testing.T) {
+	tests := []struct {
+		val      int64
+		want     time.Time
+		encoding TimeEncoding
+	}{
+		{99999999999, time.Unix(99999999999, 0), TIME_EPOCH_SECONDS},
+		{100000000000, time.UnixMilli(100000000000), TIME_EPOCH_MILLIS},
+		{99999999999999, time.UnixMilli(99999999999999), TIME_EPOCH_MILLIS},
+		{100000000000000, time.UnixMicro(100000000000000), TIME_EPOCH_MICROS},
+		{99999999999999999, time.UnixMicro(99999999999999999), TIME_EPOCH_MICROS},
+		{100000000000000000, time.Unix(0, 100000000000000000), TIME_EPOCH_NANOS},
+		{1665000000123456789, time.Unix(0, 1665000000123456789), TIME_EPOCH_NANOS},
+		{math.MaxInt64, time.Unix(0, math.MaxInt64), TIME_EPOCH_NANOS},
+		{math.MinInt64, time.Unix(0, math.MinInt64), TIME_EPOCH_NANOS},
+		{-99999999999, time.Unix(-99999999999, 0), TIME_EPOCH_SECONDS},
+	}
+
+	for _, test := range tests {
+		ts, enc, err := decodeEpochInt(test.val)
+		if err != nil {
+			t.Errorf("%d: %s", test.val, err.Error())
+			continue
+		}
+
+		if enc != test.encoding {
+			t.Errorf("%d: expected %s, got %s", test.val, test.encoding, enc)
+		}
+
+		if !ts.Equal(test.want) {
+			t.Errorf("%d: expected %s, got %s", test.val, test.want, ts)
+		}
+	}
+}
+
+func TestDecodeTimeString(t *testing.T) {
+	utc := func(y int, mo time.Month, d, h, mi, s, ns int) time.Time {
+		return time.Date(y, mo, d, h, mi, s, ns, time.UTC)
+	}
+
+	tests := []struct {
+		val      string
+		want     time.Time
+		encoding TimeEncoding
+	}{
+		{"1650000000", time.Unix(1650000000, 0), TIME_EPOCH_SECONDS},
+		{" 1650000000.25 ", time.Unix(1650000000, 250000000), TIME_EPOCH_SECONDS},
+		{"1650000000506", time.UnixMilli(1650000000506), TIME_EPOCH_MILLIS},
+		{"1665000000123456789", time.Unix(0, 1665000000123456789), TIME_EPOCH_NANOS},
+		{"2022-04-15T05:20:00.506Z", utc(2022, 4, 15, 5, 20, 0, 506000000), TIME_RFC3339},
+		{"2022-04-15T07:20:00.506+0200", utc(2022, 4, 15, 5, 20, 0, 506000000), TIME_ISO8601},
+		{"2022-04-15T05:20:00", utc(2022, 4, 15, 5, 20, 0, 0), TIME_ISO8601},
+		{"2022-04-15 05:20:00.5", utc(2022, 4, 15, 5, 20, 0, 500000000), TIME_ISO8601},
+		{"Fri, 15 Apr 2022 05:20:00 +0000", utc(2022, 4, 15, 5, 20, 0, 0), TIME_LAYOUT},
+	}
+
+	for _, test := range tests {
+		ts, enc, err := decodeTimeString(test.val)
+		if err != nil {
+			t.Errorf("%q: %s", test.val, err.Error())
+			continue
+		}
+
+		if enc != test.encoding {
+			t.Errorf("%q: expected %s, got %s", test.val, test.encoding, enc)
+		}
+
+		if !ts.Equal(test.want) {
+			t.Errorf("%q: expected %s, got %s", test.val, test.want, ts)
+		}
+	}
+}
+
+func TestDecodeTimeStringInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"yesterday",
+		"NaN",
+		"1e30",
+		"99999999999999999999",
+		"2022-13-45T99:00:00Z",
+	}
+
+	for _, val := range tests {
+		if ts, _, err := decodeTimeString(val); err == nil {
+			t.Errorf("%q: expected an error, got %s", val, ts)
+		}
+	}
+}
+
+func TestDecodeTimeNumber(t *testing.T) {
+	want := time.Unix(0, 1665000000123456789)
+
+	ts, enc, err := DecodeTime(json.Number("1665000000123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if enc != TIME_EPOCH_NANOS || !ts.Equal(want) {
+		t.Errorf("Expected %s, got %s (%s)", want, ts, enc)
+	}
+
+	if _, _, err := DecodeTime(true); err == nil {
+		t.Errorf("Expected an error for a boolean timestamp")
+	}
+}
+
+/* timestamp_test.go ends here. */
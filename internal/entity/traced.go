@@ -0,0 +1,118 @@
+/*
+ * traced.go --- Base type for log entries carrying a stack trace.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type Traced struct {
+	Base
+	Trace Stacktrace
+
+	// The trace was parsed from the attached lines.
+	attachedTrace bool
+}
+
+func (t *Traced) DisplayTo(w io.Writer) {
+	t.displayHead(w)
+	t.displayRest(w)
+	t.displayTrace(w)
+	t.displayAttached(w)
+	fmt.Fprintf(w, "\n")
+}
+
+func (t *Traced) Display() {
+	t.DisplayTo(os.Stdout)
+}
+
+func (t *Traced) displayTrace(w io.Writer) {
+	if t.Trace.Len() == 0 && t.Trace.Message == "" {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s\n", GetRenderer().Style(STYLE_KEY, "Stack trace:"))
+	t.Trace.DisplayTo(w)
+}
+
+func (t *Traced) Compose(key string, value interface{}) bool {
+	var seen bool
+
+	seen = t.Base.Compose(key, value)
+
+	if !seen {
+		switch key {
+		case FIELD_STACKTRACE:
+			if trace, ok := value.(string); ok {
+				t.Trace = NewStacktraceFromString(trace)
+			}
+			t.keep(key, value)
+			seen = true
+		}
+	}
+
+	return seen
+}
+
+// Attach continuation lines, parsing them as a stack trace if the entry
+// did not carry one itself, as happens when a Go program panics.  Lines
+// that do not give the file and line of at least one frame are not
+// taken to be a trace.
+func (t *Traced) Attach(lines []string) {
+	t.Base.Attach(lines)
+
+	if t.Trace.Len() > 0 {
+		return
+	}
+
+	text := strings.Join(lines, "\n")
+	trace := NewStacktraceFromString(text)
+	if trace.Located() {
+		t.Trace = trace
+		t.attachedTrace = true
+		t.keep(FIELD_STACKTRACE, text)
+	}
+}
+
+func (t *Traced) Field(path string) (interface{}, bool) {
+	if path == FIELD_STACKTRACE {
+		return t.Trace, t.Trace.Len() > 0 || t.Trace.Message != ""
+	}
+
+	return t.Base.Field(path)
+}
+
+func (t *Traced) Fields() []Field {
+	fields := t.logicalFields()
+
+	if value, ok := t.Field(FIELD_STACKTRACE); ok {
+		fields = append(fields, Field{Path: FIELD_STACKTRACE, Value: value})
+	}
+
+	return append(fields, t.restFields()...)
+}
+
+/* traced.go ends here. */
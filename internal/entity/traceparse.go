@@ -0,0 +1,395 @@
+/*
+ * traceparse.go --- Stack trace parsers.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// `goroutine 1 [running]:` or `goroutine 7 [chan receive, 5 minutes]:`
+	goGoroutineRx = regexp.MustCompile(`^goroutine (\d+) \[([^\]]*)\]:?$`)
+
+	// `/path/to/file.go:123 +0x1a`
+	goFileRx = regexp.MustCompile(`^(.+?):(\d+)(?: \+(0x[0-9a-fA-F]+))?$`)
+
+	// `created by main.main in goroutine 1`
+	goCreatedByRx = regexp.MustCompile(`^created by (.+?)(?: in goroutine \d+)?$`)
+
+	// `at com.example.Foo.bar(Foo.java:42)`
+	javaFrameRx = regexp.MustCompile(`^at ([^\s(]+)\(([^)]*)\)$`)
+
+	// `... 5 more`
+	javaMoreRx = regexp.MustCompile(`^\.\.\. \d+ more$`)
+
+	// `File "/path/to/file.py", line 10, in func`
+	pythonFrameRx = regexp.MustCompile(`^File "([^"]+)", line (\d+)(?:, in (.+))?$`)
+)
+
+const (
+	pythonTraceHeader string = "Traceback (most recent call last):"
+)
+
+// Split trace text into lines, dropping blank lines and carriage
+// returns.
+func splitTraceLines(trace string) []string {
+	var lines []string = []string{}
+
+	for _, line := range strings.Split(trace, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// ==================================================================
+// {{{ Go:
+
+// Split a Go function such as `github.com/a/b.(*T).M(0x1, 0x2)` into
+// its package, function and arguments.
+func splitGoFunction(text string) (string, string, string) {
+	args := ""
+
+	if strings.HasSuffix(text, ")") {
+		depth := 0
+
+		for idx := len(text) - 1; idx >= 0; idx-- {
+			switch text[idx] {
+			case ')':
+				depth++
+
+			case '(':
+				depth--
+			}
+
+			if depth == 0 {
+				// `(*T)` in the middle of a name is not an argument list.
+				if idx > 0 && text[idx-1] != '.' {
+					args = text[idx+1 : len(text)-1]
+					text = text[:idx]
+				}
+
+				break
+			}
+		}
+	}
+
+	slash := strings.LastIndex(text, "/")
+	dot := strings.Index(text[slash+1:], ".")
+	if dot == -1 {
+		return "", text, args
+	}
+
+	dot += slash + 1
+
+	return text[:dot], text[dot+1:], args
+}
+
+func parseGoTrace(lines []string) Stacktrace {
+	var trace Stacktrace = Stacktrace{Kind: TRACE_GO}
+	var thread *StacktraceThread = nil
+	var frame *StacktraceLine = nil
+	var message []string = []string{}
+	var ended bool = false
+
+	flush := func() {
+		if frame != nil {
+			thread.Frames = append(thread.Frames, *frame)
+			frame = nil
+		}
+	}
+
+	newThread := func(id int, state string) {
+		flush()
+
+		trace.Threads = append(trace.Threads, StacktraceThread{
+			ID:     id,
+			State:  state,
+			Frames: []StacktraceLine{},
+		})
+		thread = &trace.Threads[len(trace.Threads)-1]
+	}
+
+	for idx := range lines {
+		text := strings.TrimSpace(lines[idx])
+
+		if match := goGoroutineRx.FindStringSubmatch(text); match != nil {
+			id, _ := strconv.Atoi(match[1])
+			newThread(id, match[2])
+			trace.Threads[len(trace.Threads)-1].Name = "goroutine " + match[1]
+			ended = false
+			continue
+		}
+
+		// Text after a goroutine, such as `exit status 2`, is neither a
+		// frame nor followed by one, and ends the goroutine.
+		if ended {
+			continue
+		}
+
+		if thread != nil && !isGoFileLine(lines[idx]) &&
+			(idx+1 >= len(lines) || !isGoFileLine(lines[idx+1])) {
+			flush()
+			thread = nil
+			ended = true
+			continue
+		}
+
+		// Text before the first goroutine, such as `panic: ...`.
+		if thread == nil && len(trace.Threads) == 0 && !strings.HasPrefix(lines[idx], "\t") {
+			if idx+1 < len(lines) && isGoFileLine(lines[idx+1]) {
+				// zap traces have no goroutine header.
+				newThread(0, "")
+			} else {
+				message = append(message, text)
+				continue
+			}
+		}
+
+		if thread == nil {
+			newThread(0, "")
+		}
+
+		if frame != nil && isGoFileLine(lines[idx]) {
+			match := goFileRx.FindStringSubmatch(text)
+			frame.File = match[1]
+			frame.Line, _ = strconv.Atoi(match[2])
+			if match[3] != "" {
+				frame.Offset = "+" + match[3]
+			}
+
+			flush()
+			continue
+		}
+
+		flush()
+		frame = &StacktraceLine{}
+
+		if match := goCreatedByRx.FindStringSubmatch(text); match != nil {
+			frame.CreatedBy = true
+			text = match[1]
+		}
+
+		frame.Package, frame.Function, frame.Args = splitGoFunction(text)
+	}
+
+	if thread != nil {
+		flush()
+	}
+
+	trace.Message = strings.Join(message, "\n")
+
+	return trace
+}
+
+func isGoFileLine(line string) bool {
+	text := strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+		return false
+	}
+
+	return goFileRx.MatchString(text) && strings.Contains(text, ".go:")
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ Java:
+
+func isJavaTrace(lines []string) bool {
+	for idx := range lines {
+		if javaFrameRx.MatchString(strings.TrimSpace(lines[idx])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Split `com.example.Foo.bar` into `com.example` and `Foo.bar`.
+func splitJavaFunction(text string) (string, string) {
+	method := strings.LastIndex(text, ".")
+	if method == -1 {
+		return "", text
+	}
+
+	class := strings.LastIndex(text[:method], ".")
+	if class == -1 {
+		return "", text
+	}
+
+	return text[:class], text[class+1:]
+}
+
+func parseJavaTrace(lines []string) Stacktrace {
+	var trace Stacktrace = Stacktrace{Kind: TRACE_JAVA}
+	var thread *StacktraceThread = nil
+
+	for idx := range lines {
+		text := strings.TrimSpace(lines[idx])
+
+		if javaMoreRx.MatchString(text) {
+			continue
+		}
+
+		match := javaFrameRx.FindStringSubmatch(text)
+		if match == nil {
+			// Exception header, or a `Caused by:` in the chain.
+			if len(trace.Threads) == 0 && trace.Message == "" {
+				trace.Message = text
+			}
+
+			trace.Threads = append(trace.Threads, StacktraceThread{
+				ID:     len(trace.Threads),
+				Name:   text,
+				Frames: []StacktraceLine{},
+			})
+			thread = &trace.Threads[len(trace.Threads)-1]
+			continue
+		}
+
+		if thread == nil {
+			trace.Threads = append(trace.Threads, StacktraceThread{
+				Frames: []StacktraceLine{},
+			})
+			thread = &trace.Threads[len(trace.Threads)-1]
+		}
+
+		frame := StacktraceLine{}
+		frame.Package, frame.Function = splitJavaFunction(match[1])
+
+		// `Foo.java:42`, `Unknown Source` or `Native Method`.
+		if pos := strings.LastIndex(match[2], ":"); pos > -1 {
+			frame.File = match[2][:pos]
+			frame.Line, _ = strconv.Atoi(match[2][pos+1:])
+		} else {
+			frame.File = match[2]
+		}
+
+		thread.Frames = append(thread.Frames, frame)
+	}
+
+	// The first thread's name is the message.
+	if len(trace.Threads) > 0 && trace.Threads[0].Name == trace.Message {
+		trace.Threads[0].Name = ""
+	}
+
+	return trace
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ Python:
+
+func isPythonTrace(lines []string) bool {
+	for idx := range lines {
+		if strings.TrimSpace(lines[idx]) == pythonTraceHeader {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Chained exceptions give one traceback each, the one raised last
+// coming last.  Each becomes a thread, the one raised first, so that
+// the trace reads like a Java `Caused by:` chain.
+func parsePythonTrace(lines []string) Stacktrace {
+	var trace Stacktrace = Stacktrace{Kind: TRACE_PYTHON}
+	var threads []StacktraceThread = []StacktraceThread{}
+	var thread *StacktraceThread = nil
+
+	for idx := range lines {
+		text := strings.TrimSpace(lines[idx])
+
+		if text == pythonTraceHeader {
+			threads = append(threads, StacktraceThread{
+				Frames: []StacktraceLine{},
+			})
+			thread = &threads[len(threads)-1]
+			continue
+		}
+
+		if thread == nil {
+			continue
+		}
+
+		if match := pythonFrameRx.FindStringSubmatch(text); match != nil {
+			frame := StacktraceLine{
+				File:     match[1],
+				Function: match[3],
+			}
+			frame.Line, _ = strconv.Atoi(match[2])
+			thread.Frames = append(thread.Frames, frame)
+			continue
+		}
+
+		// Unindented text after the frames is the exception, until a
+		// line such as `During handling of the above exception...`
+		// that leads to the next traceback.
+		if !strings.HasPrefix(lines[idx], " ") {
+			if idx+1 < len(lines) && strings.TrimSpace(lines[idx+1]) == pythonTraceHeader {
+				continue
+			}
+
+			thread.Name = text
+		}
+	}
+
+	for idx := len(threads) - 1; idx >= 0; idx-- {
+		frames := threads[idx].Frames
+
+		// Python lists the most recent call last.
+		for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+			frames[i], frames[j] = frames[j], frames[i]
+		}
+
+		threads[idx].ID = len(trace.Threads)
+		trace.Threads = append(trace.Threads, threads[idx])
+	}
+
+	// The first thread's name is the message.
+	if len(trace.Threads) > 0 {
+		trace.Message = trace.Threads[0].Name
+		trace.Threads[0].Name = ""
+	}
+
+	return trace
+}
+
+// }}}
+// ==================================================================
+
+/* traceparse.go ends here. */
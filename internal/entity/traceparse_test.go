@@ -0,0 +1,278 @@
+/*
+ * traceparse_test.go --- Stack trace parser tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entity
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The parts of a parsed trace checked by the tests.
+type traceShape struct {
+	kind    string
+	message string
+	threads []threadShape
+}
+
+type threadShape struct {
+	name   string
+	frames []string
+}
+
+// Describe a frame as `function file:line`.
+func frameShape(frame StacktraceLine) string {
+	return fmt.Sprintf("%s %s:%d", frame.Name(), frame.File, frame.Line)
+}
+
+func checkTrace(t *testing.T, name string, got Stacktrace, want traceShape) {
+	if got.Kind != want.kind {
+		t.Errorf("%s: expected kind %q, got %q", name, want.kind, got.Kind)
+	}
+
+	if got.Message != want.message {
+		t.Errorf("%s: expected message %q, got %q", name, want.message, got.Message)
+	}
+
+	if len(got.Threads) != len(want.threads) {
+		t.Errorf("%s: expected %d threads, got %#v", name, len(want.threads), got.Threads)
+		return
+	}
+
+	for tidx, thread := range want.threads {
+		if got.Threads[tidx].Name != thread.name {
+			t.Errorf("%s: thread %d: expected name %q, got %q",
+				name, tidx, thread.name, got.Threads[tidx].Name)
+		}
+
+		frames := got.Threads[tidx].Frames
+		if len(frames) != len(thread.frames) {
+			t.Errorf("%s: thread %d: expected %d frames, got %#v",
+				name, tidx, len(thread.frames), frames)
+			continue
+		}
+
+		for idx := range frames {
+			if shape := frameShape(frames[idx]); shape != thread.frames[idx] {
+				t.Errorf("%s: thread %d frame %d: expected %q, got %q",
+					name, tidx, idx, thread.frames[idx], shape)
+			}
+		}
+	}
+}
+
+func TestParseGoTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace string
+		want  traceShape
+	}{
+		{
+			"exit status",
+			"panic: boom\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.(*Server).Run(0xc000010000, {0x1, 0x2})\n" +
+				"\t/src/main.go:12 +0x1d\n" +
+				"main.main()\n" +
+				"\t/src/main.go:20 +0x25\n" +
+				"exit status 2\n",
+			traceShape{TRACE_GO, "panic: boom", []threadShape{
+				{"goroutine 1", []string{
+					"main.(*Server).Run /src/main.go:12",
+					"main.main /src/main.go:20",
+				}},
+			}},
+		},
+		{
+			"several goroutines",
+			"panic: boom\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.main()\n" +
+				"\t/src/main.go:20 +0x25\n\n" +
+				"goroutine 7 [chan receive, 5 minutes]:\n" +
+				"main.worker(...)\n" +
+				"\t/src/worker.go:8\n" +
+				"created by main.main in goroutine 1\n" +
+				"\t/src/main.go:15 +0x3c\n" +
+				"exit status 2\n",
+			traceShape{TRACE_GO, "panic: boom", []threadShape{
+				{"goroutine 1", []string{
+					"main.main /src/main.go:20",
+				}},
+				{"goroutine 7", []string{
+					"main.worker /src/worker.go:8",
+					"main.main /src/main.go:15",
+				}},
+			}},
+		},
+		{
+			"zap",
+			"main.handler\n" +
+				"\t/src/main.go:12\n" +
+				"net/http.HandlerFunc.ServeHTTP\n" +
+				"\t/usr/lib/go/src/net/http/server.go:2084\n",
+			traceShape{TRACE_GO, "", []threadShape{
+				{"", []string{
+					"main.handler /src/main.go:12",
+					"net/http.HandlerFunc.ServeHTTP /usr/lib/go/src/net/http/server.go:2084",
+				}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got := NewStacktraceFromString(test.trace)
+		checkTrace(t, test.name, got, test.want)
+	}
+}
+
+func TestParseGoTraceCreatedBy(t *testing.T) {
+	trace := NewStacktraceFromString(
+		"goroutine 7 [running]:\n" +
+			"main.worker()\n" +
+			"\t/src/worker.go:8\n" +
+			"created by main.main in goroutine 1\n" +
+			"\t/src/main.go:15 +0x3c\n",
+	)
+
+	frames := trace.Frames()
+	if len(frames) != 2 || frames[0].CreatedBy || !frames[1].CreatedBy {
+		t.Errorf("Expected the second frame to be the creator, got %#v", frames)
+	}
+
+	if len(frames) == 2 && frames[1].Offset != "+0x3c" {
+		t.Errorf("Expected %q, got %q", "+0x3c", frames[1].Offset)
+	}
+}
+
+func TestSplitGoFunction(t *testing.T) {
+	tests := []struct {
+		text     string
+		pkg      string
+		function string
+		args     string
+	}{
+		{"main.main()", "main", "main", ""},
+		{"main.main.func1()", "main", "main.func1", ""},
+		{"github.com/a/b.(*T).M(0x1, 0x2)", "github.com/a/b", "(*T).M", "0x1, 0x2"},
+		{"github.com/a/b.(*T).M", "github.com/a/b", "(*T).M", ""},
+		{"github.com/a/b.T.M({0x1, 0x2}, ...)", "github.com/a/b", "T.M", "{0x1, 0x2}, ..."},
+		{"github.com/a/b.v1.(*T).M()", "github.com/a/b", "v1.(*T).M", ""},
+		{"runtime.gopark", "runtime", "gopark", ""},
+		{"gopark", "", "gopark", ""},
+	}
+
+	for _, test := range tests {
+		pkg, function, args := splitGoFunction(test.text)
+		if pkg != test.pkg || function != test.function || args != test.args {
+			t.Errorf("%q: expected %q %q %q, got %q %q %q", test.text,
+				test.pkg, test.function, test.args, pkg, function, args)
+		}
+	}
+}
+
+func TestParseJavaTrace(t *testing.T) {
+	trace := "java.lang.RuntimeException: outer\n" +
+		"\tat com.example.Foo.bar(Foo.java:42)\n" +
+		"\tat com.example.Main.main(Main.java:5)\n" +
+		"Caused by: java.io.IOException: inner\n" +
+		"\tat com.example.Io.read(Io.java:7)\n" +
+		"\tat java.base/java.io.FileInputStream.readBytes(Native Method)\n" +
+		"\t... 2 more\n"
+
+	checkTrace(t, "java", NewStacktraceFromString(trace), traceShape{
+		TRACE_JAVA, "java.lang.RuntimeException: outer", []threadShape{
+			{"", []string{
+				"com.example.Foo.bar Foo.java:42",
+				"com.example.Main.main Main.java:5",
+			}},
+			{"Caused by: java.io.IOException: inner", []string{
+				"com.example.Io.read Io.java:7",
+				"java.base/java.io.FileInputStream.readBytes Native Method:0",
+			}},
+		},
+	})
+}
+
+func TestParsePythonTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace string
+		want  traceShape
+	}{
+		{
+			"single",
+			"Traceback (most recent call last):\n" +
+				"  File \"a.py\", line 9, in <module>\n" +
+				"    f()\n" +
+				"  File \"a.py\", line 3, in f\n" +
+				"    raise KeyError('x')\n" +
+				"KeyError: 'x'\n",
+			traceShape{TRACE_PYTHON, "KeyError: 'x'", []threadShape{
+				{"", []string{"f a.py:3", "<module> a.py:9"}},
+			}},
+		},
+		{
+			"during handling",
+			"Traceback (most recent call last):\n" +
+				"  File \"a.py\", line 3, in f\n" +
+				"    g()\n" +
+				"  File \"a.py\", line 6, in g\n" +
+				"    raise KeyError('x')\n" +
+				"KeyError: 'x'\n\n" +
+				"During handling of the above exception, another exception occurred:\n\n" +
+				"Traceback (most recent call last):\n" +
+				"  File \"a.py\", line 9, in <module>\n" +
+				"    f()\n" +
+				"ValueError: bad\n",
+			traceShape{TRACE_PYTHON, "ValueError: bad", []threadShape{
+				{"", []string{"<module> a.py:9"}},
+				{"KeyError: 'x'", []string{"g a.py:6", "f a.py:3"}},
+			}},
+		},
+		{
+			"direct cause",
+			"Traceback (most recent call last):\n" +
+				"  File \"a.py\", line 2, in load\n" +
+				"    open(name)\n" +
+				"FileNotFoundError: [Errno 2] No such file\n\n" +
+				"The above exception was the direct cause of the following exception:\n\n" +
+				"Traceback (most recent call last):\n" +
+				"  File \"a.py\", line 7, in <module>\n" +
+				"    load('x')\n" +
+				"  File \"a.py\", line 4, in load\n" +
+				"    raise ConfigError() from err\n" +
+				"ConfigError\n",
+			traceShape{TRACE_PYTHON, "ConfigError", []threadShape{
+				{"", []string{"load a.py:4", "<module> a.py:7"}},
+				{"FileNotFoundError: [Errno 2] No such file", []string{"load a.py:2"}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got := NewStacktraceFromString(test.trace)
+		checkTrace(t, test.name, got, test.want)
+	}
+}
+
+/* traceparse_test.go ends here. */
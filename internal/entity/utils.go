@@ -27,10 +27,14 @@ import (
 	"time"
 )
 
+// Convert epoch seconds to a time.
+//
+// A float64 holds current epoch times to well under a microsecond, so
+// the fraction is rounded to microseconds rather than showing noise.
 func FloatToTime(val float64) time.Time {
 	sec, dec := math.Modf(val)
 
-	return time.Unix(int64(sec), int64(dec*(1e9)))
+	return time.Unix(int64(sec), int64(math.Round(dec*1e6))*1e3)
 }
 
 /* utils.go ends here. */
@@ -0,0 +1,162 @@
+/*
+ * cache.go --- Cache of indexes.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Directory under the user's cache directory holding indexes.
+	INDEX_DIR string = "gotools/index"
+
+	// Default limit on the size of the index cache.
+	INDEX_CACHE_LIMIT int64 = 256 * 1024 * 1024
+)
+
+var (
+	indexCacheLock  sync.RWMutex
+	indexCache      bool  = false
+	indexCacheLimit int64 = INDEX_CACHE_LIMIT
+
+	// Names of finished indexes.  Temporary files being written, by
+	// this process or another, and anything else are left alone.
+	cacheNameRx = regexp.MustCompile(`^[0-9a-f]{40}\.(?:idx|lines)$`)
+)
+
+// Set whether indexes are kept in the user's cache directory between
+// runs.  The cache is off by default.
+//
+// The sidecar of a compressed file is a recompressed copy of all of its
+// data, about as large as the file itself, so with the cache on every
+// compressed log opened leaves a copy in the cache until it is pruned.
+// Without the cache, the copy is a temporary file removed on close.
+func SetIndexCache(enable bool) {
+	indexCacheLock.Lock()
+	defer indexCacheLock.Unlock()
+
+	indexCache = enable
+}
+
+func IndexCache() bool {
+	indexCacheLock.RLock()
+	defer indexCacheLock.RUnlock()
+
+	return indexCache
+}
+
+// Set the largest size in bytes of the index cache.  When it is larger,
+// the least recently used indexes are removed.
+func SetIndexCacheLimit(limit int64) {
+	indexCacheLock.Lock()
+	defer indexCacheLock.Unlock()
+
+	if limit <= 0 {
+		limit = INDEX_CACHE_LIMIT
+	}
+
+	indexCacheLimit = limit
+}
+
+func IndexCacheLimit() int64 {
+	indexCacheLock.RLock()
+	defer indexCacheLock.RUnlock()
+
+	return indexCacheLimit
+}
+
+// Return the path of a file in the index cache for the given file.
+func cachePath(path, ext string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	sum := sha1.Sum([]byte(abs))
+
+	return filepath.Join(dir, INDEX_DIR, hex.EncodeToString(sum[:])+ext)
+}
+
+// Mark a cached index as used, so that it is removed last.
+func touchCached(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Remove the least recently used indexes until the cache is within its
+// limit, keeping the given one.  Only finished indexes are removed.
+func pruneCache(keep string) {
+	dir := filepath.Dir(keep)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	total := int64(0)
+
+	for idx := range entries {
+		if !cacheNameRx.MatchString(entries[idx].Name()) {
+			continue
+		}
+
+		info, err := entries[idx].Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		infos = append(infos, info)
+		total += info.Size()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	limit := IndexCacheLimit()
+	for idx := 0; idx < len(infos) && total > limit; idx++ {
+		path := filepath.Join(dir, infos[idx].Name())
+		if path == keep {
+			continue
+		}
+
+		if os.Remove(path) == nil {
+			total -= infos[idx].Size()
+		}
+	}
+}
+
+/* cache.go ends here. */
@@ -0,0 +1,488 @@
+/*
+ * compressed.go --- Compressed log files.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	COMPRESS_NONE  string = ""
+	COMPRESS_GZIP  string = "gzip"
+	COMPRESS_BZIP2 string = "bzip2"
+	COMPRESS_ZLIB  string = "zlib"
+	COMPRESS_ZSTD  string = "zstd"
+
+	// Amount of uncompressed data between checkpoints.
+	CHECKPOINT_SIZE int = 4 * 1024 * 1024
+
+	// Number of decompressed checkpoints kept in memory.
+	CHECKPOINT_CACHE int = 4
+
+	indexMagic   string = "GTIDX1\n"
+	indexVersion int    = 1
+)
+
+// The data mapped by a MemFile.
+type backing interface {
+	io.ReaderAt
+	io.Closer
+
+	Len() int
+}
+
+// A point from which the uncompressed data can be read.
+//
+// A checkpoint is not a saved decompressor state.  The standard library
+// cannot resume decompression part way through a stream, so the data
+// between checkpoints is recompressed into the sidecar as a gzip member
+// of its own.  The sidecar is therefore a full copy of the file, about
+// as large as the file itself.
+type Checkpoint struct {
+	Offset int64 `json:"offset"`
+	Size   int   `json:"size"`
+	Stored int64 `json:"stored"`
+}
+
+// The index of a compressed file, written at the end of its sidecar
+// after the recompressed data.
+type Index struct {
+	Version     int          `json:"version"`
+	Format      string       `json:"format"`
+	Source      string       `json:"source"`
+	SourceSize  int64        `json:"source_size"`
+	SourceTime  int64        `json:"source_time"`
+	Length      int64        `json:"length"`
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+// Work out how a file is compressed from its first few bytes.
+func DetectCompression(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return COMPRESS_GZIP
+
+	case len(head) >= 4 && bytes.HasPrefix(head, []byte("BZh")) && head[3] >= '1' && head[3] <= '9':
+		return COMPRESS_BZIP2
+
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return COMPRESS_ZSTD
+
+	// Only the usual zlib headers, as others could begin a text file.
+	case len(head) >= 2 && head[0] == 0x78 && (head[1] == 0x01 || head[1] == 0x9c || head[1] == 0xda):
+		return COMPRESS_ZLIB
+	}
+
+	return COMPRESS_NONE
+}
+
+func decompressor(format string, rdr io.Reader) (io.Reader, error) {
+	switch format {
+	case COMPRESS_GZIP:
+		return gzip.NewReader(rdr)
+
+	case COMPRESS_BZIP2:
+		return bzip2.NewReader(rdr), nil
+
+	case COMPRESS_ZLIB:
+		return zlib.NewReader(rdr)
+	}
+
+	return nil, fmt.Errorf("Cannot read %s compressed files", format)
+}
+
+// Return the path of the sidecar holding the recompressed copy and index
+// of the given file, or an empty string if there is no user cache
+// directory.
+func IndexPath(path string) string {
+	return cachePath(path, ".idx")
+}
+
+// ==================================================================
+// {{{ Reading:
+
+type chunk struct {
+	index int
+	data  []byte
+}
+
+type compressedReader struct {
+	sidecar *os.File
+	path    string
+	index   *Index
+	cache   []chunk
+
+	// Remove the sidecar when closed, as it could not be cached.
+	temporary bool
+}
+
+// Open a compressed file, using its cached index or building one.
+func openCompressed(file *os.File, info os.FileInfo, format string) (*compressedReader, error) {
+	path := ""
+	if IndexCache() {
+		path = IndexPath(file.Name())
+	}
+
+	if path != "" {
+		if cr, err := loadIndex(path, info); err == nil {
+			touchCached(path)
+			return cr, nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			path = ""
+		}
+	}
+
+	cr, err := buildIndex(file, info, format, path)
+	if err == nil && path != "" {
+		pruneCache(path)
+	}
+
+	return cr, err
+}
+
+// Load an index, which must be for the file as it is now.
+func loadIndex(path string, info os.FileInfo) (*compressedReader, error) {
+	sidecar, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readIndex(sidecar)
+	if err != nil {
+		sidecar.Close()
+		return nil, err
+	}
+
+	if index.Version != indexVersion ||
+		index.SourceSize != info.Size() ||
+		index.SourceTime != info.ModTime().UnixNano() {
+		sidecar.Close()
+		return nil, errors.New("Index is out of date")
+	}
+
+	return &compressedReader{sidecar: sidecar, path: path, index: index}, nil
+}
+
+// Read the index from the end of a sidecar, which ends with the size of
+// the index.
+func readIndex(sidecar *os.File) (*Index, error) {
+	var size int64
+
+	info, err := sidecar.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	trailer := make([]byte, 8)
+	if info.Size() < int64(len(indexMagic)+len(trailer)) {
+		return nil, errors.New("Index is truncated")
+	}
+
+	if _, err := sidecar.ReadAt(trailer, info.Size()-8); err != nil {
+		return nil, err
+	}
+
+	size = int64(binary.BigEndian.Uint64(trailer))
+	if size <= 0 || size > info.Size()-8 {
+		return nil, errors.New("Index is corrupt")
+	}
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := sidecar.ReadAt(magic, 0); err != nil || string(magic) != indexMagic {
+		return nil, errors.New("Not an index")
+	}
+
+	data := make([]byte, size)
+	if _, err := sidecar.ReadAt(data, info.Size()-8-size); err != nil {
+		return nil, err
+	}
+
+	index := &Index{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Return a reader of the same data with a cache of its own, so that
+// it can be used by another goroutine.
+func (cr *compressedReader) clone() *compressedReader {
+	return &compressedReader{sidecar: cr.sidecar, path: cr.path, index: cr.index}
+}
+
+// Return the path and size of the sidecar.
+func (cr *compressedReader) Sidecar() (string, int64) {
+	info, err := cr.sidecar.Stat()
+	if err != nil {
+		return cr.path, 0
+	}
+
+	return cr.path, info.Size()
+}
+
+func (cr *compressedReader) Len() int {
+	return int(cr.index.Length)
+}
+
+func (cr *compressedReader) Close() error {
+	err := cr.sidecar.Close()
+
+	if cr.temporary {
+		os.Remove(cr.path)
+	}
+
+	return err
+}
+
+// Return the data of the checkpoint holding the given offset.
+func (cr *compressedReader) load(offset int64) (int, []byte, error) {
+	points := cr.index.Checkpoints
+	idx := sort.Search(len(points), func(i int) bool {
+		return points[i].Offset+int64(points[i].Size) > offset
+	})
+
+	if idx == len(points) {
+		return 0, nil, io.EOF
+	}
+
+	for pos := range cr.cache {
+		if cr.cache[pos].index == idx {
+			// Keep recently used checkpoints at the front.
+			found := cr.cache[pos]
+			copy(cr.cache[1:pos+1], cr.cache[:pos])
+			cr.cache[0] = found
+
+			return idx, found.data, nil
+		}
+	}
+
+	section := io.NewSectionReader(cr.sidecar, points[idx].Stored, cr.sidecarEnd(idx)-points[idx].Stored)
+	zrdr, err := gzip.NewReader(section)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data := make([]byte, points[idx].Size)
+	if _, err := io.ReadFull(zrdr, data); err != nil {
+		return 0, nil, err
+	}
+
+	if len(cr.cache) == CHECKPOINT_CACHE {
+		cr.cache = cr.cache[:CHECKPOINT_CACHE-1]
+	}
+	cr.cache = append([]chunk{{index: idx, data: data}}, cr.cache...)
+
+	return idx, data, nil
+}
+
+// Where the stored data of a checkpoint ends.
+func (cr *compressedReader) sidecarEnd(idx int) int64 {
+	if idx+1 < len(cr.index.Checkpoints) {
+		return cr.index.Checkpoints[idx+1].Stored
+	}
+
+	info, err := cr.sidecar.Stat()
+	if err != nil {
+		return cr.index.Checkpoints[idx].Stored
+	}
+
+	return info.Size()
+}
+
+func (cr *compressedReader) ReadAt(buf []byte, offset int64) (int, error) {
+	var done int
+
+	if offset < 0 {
+		return 0, errors.New("Negative offset")
+	}
+
+	for done < len(buf) {
+		if offset+int64(done) >= cr.index.Length {
+			return done, io.EOF
+		}
+
+		idx, data, err := cr.load(offset + int64(done))
+		if err != nil {
+			return done, err
+		}
+
+		start := offset + int64(done) - cr.index.Checkpoints[idx].Offset
+		done += copy(buf[done:], data[start:])
+	}
+
+	return done, nil
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ Building:
+
+// Decompress a file, storing each checkpoint's data in a new sidecar at
+// the given path.  Without a path the sidecar is a temporary file that
+// is removed when the file is closed.
+func buildIndex(file *os.File, info os.FileInfo, format, path string) (*compressedReader, error) {
+	var sidecar *os.File
+	var err error
+
+	if path != "" {
+		sidecar, err = os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	}
+
+	if path == "" || err != nil {
+		path = ""
+		sidecar, err = os.CreateTemp("", "gotools-*.idx")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	index, err := writeIndex(file, info, format, sidecar)
+	if err == nil && path != "" {
+		err = os.Rename(sidecar.Name(), path)
+	}
+
+	if err != nil {
+		sidecar.Close()
+		os.Remove(sidecar.Name())
+		return nil, err
+	}
+
+	if path == "" {
+		path = sidecar.Name()
+	}
+
+	return &compressedReader{
+		sidecar:   sidecar,
+		path:      path,
+		index:     index,
+		temporary: path == sidecar.Name(),
+	}, nil
+}
+
+func writeIndex(file *os.File, info os.FileInfo, format string, sidecar *os.File) (*Index, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	rdr, err := decompressor(format, bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{
+		Version:     indexVersion,
+		Format:      format,
+		Source:      file.Name(),
+		SourceSize:  info.Size(),
+		SourceTime:  info.ModTime().UnixNano(),
+		Checkpoints: []Checkpoint{},
+	}
+
+	if _, err := sidecar.WriteString(indexMagic); err != nil {
+		return nil, err
+	}
+	stored := int64(len(indexMagic))
+
+	buf := make([]byte, CHECKPOINT_SIZE)
+	for {
+		n, err := io.ReadFull(rdr, buf)
+		if n > 0 {
+			size, werr := storeCheckpoint(sidecar, buf[:n])
+			if werr != nil {
+				return nil, werr
+			}
+
+			index.Checkpoints = append(index.Checkpoints, Checkpoint{
+				Offset: index.Length,
+				Size:   n,
+				Stored: stored,
+			})
+			index.Length += int64(n)
+			stored += size
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+
+	trailer := make([]byte, 8)
+	binary.BigEndian.PutUint64(trailer, uint64(len(data)))
+
+	if _, err := sidecar.Write(append(data, trailer...)); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Write checkpoint data as a gzip member, returning its stored size.
+func storeCheckpoint(sidecar *os.File, data []byte) (int64, error) {
+	var buf bytes.Buffer
+
+	zwr, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := zwr.Write(data); err != nil {
+		return 0, err
+	}
+
+	if err := zwr.Close(); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(sidecar, &buf)
+}
+
+// }}}
+// ==================================================================
+
+/* compressed.go ends here. */
@@ -0,0 +1,404 @@
+/*
+ * follow.go --- Follow a growing file.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often a followed file is checked when there is no better way
+	// of being told it has changed.
+	FOLLOW_INTERVAL time.Duration = 500 * time.Millisecond
+)
+
+// A range of complete lines added to a file.
+//
+// `Start` and `End` are byte offsets, and `Lines` is the number of
+// lines between them.
+//
+// When the file has been rotated, `Rotated` is set and the offsets are
+// into the new file.  The first `Drained` lines of the range are those
+// that were written to the old file after it was last refreshed.
+type LineRange struct {
+	Start   int64
+	End     int64
+	Lines   int
+	Rotated bool
+	Drained int
+
+	drained []string
+}
+
+func (lr LineRange) Empty() bool {
+	return lr.Lines == 0 && !lr.Rotated
+}
+
+// Re-map the file if it has grown since it was opened or last
+// refreshed, returning the range of complete lines that were added.
+//
+// A partial line at the end of the file is not included until its
+// newline is written.
+//
+// Rotation is handled as well.  A file renamed away from the path is
+// drained of the lines written to it since the last refresh, and the
+// file now at the path is followed from its start.  A file truncated
+// in place is followed from its start.
+func (mf *MemFile) Refresh() (LineRange, error) {
+	var empty LineRange = LineRange{Start: mf.followed, End: mf.followed}
+
+	if mf.compression != COMPRESS_NONE {
+		return empty, nil
+	}
+
+	info, err := os.Stat(mf.path)
+	if err != nil {
+		// The file may have been renamed and not yet recreated.
+		if errors.Is(err, os.ErrNotExist) {
+			return empty, nil
+		}
+
+		return empty, err
+	}
+
+	switch {
+	case !os.SameFile(mf.info, info):
+		return mf.renamed()
+
+	case mf.truncated(info.Size()):
+		if err := mf.mapFile(mf.path); err != nil {
+			return empty, err
+		}
+
+		return mf.restart(nil)
+
+	case info.Size() > mf.length:
+		if err := mf.mapFile(mf.path); err != nil {
+			return empty, err
+		}
+	}
+
+	return mf.added()
+}
+
+// Has the file been truncated since lines were last reported?
+//
+// A file may be truncated and written to again between refreshes, so
+// the newline ending the last reported line is checked as well as the
+// size.
+func (mf *MemFile) truncated(size int64) bool {
+	if size < mf.followed {
+		return true
+	}
+
+	if mf.followed == 0 {
+		return false
+	}
+
+	buf := []byte{0}
+	if _, err := mf.file.ReadAt(buf, mf.followed-1); err != nil {
+		return true
+	}
+
+	return buf[0] != '\n'
+}
+
+// The file has been renamed, so drain it and follow its replacement.
+func (mf *MemFile) renamed() (LineRange, error) {
+	var empty LineRange = LineRange{Start: mf.followed, End: mf.followed}
+
+	drained, err := mf.drain()
+	if err != nil {
+		return empty, err
+	}
+
+	if err := mf.mapFile(mf.path); err != nil {
+		return empty, err
+	}
+
+	return mf.restart(drained)
+}
+
+// Read whatever was written to the old file since it was last
+// refreshed.  Nothing more will be written to it, so a partial last
+// line is included.
+func (mf *MemFile) drain() ([]string, error) {
+	info, err := mf.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() <= mf.followed {
+		return []string{}, nil
+	}
+
+	buf := make([]byte, info.Size()-mf.followed)
+	if _, err := mf.file.ReadAt(buf, mf.followed); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n"), nil
+}
+
+// Follow a new file from its start.
+func (mf *MemFile) restart(drained []string) (LineRange, error) {
+	mf.followed = 0
+	mf.pos = 0
+
+	mf.index.Close()
+	if err := mf.startIndex(); err != nil {
+		return LineRange{}, err
+	}
+
+	lr, err := mf.added()
+	if err != nil {
+		return lr, err
+	}
+
+	lr.Rotated = true
+	lr.Drained = len(drained)
+	lr.Lines += lr.Drained
+	lr.drained = drained
+
+	return lr, nil
+}
+
+// Return the range of complete lines after those already reported.
+func (mf *MemFile) added() (LineRange, error) {
+	var empty LineRange = LineRange{Start: mf.followed, End: mf.followed}
+
+	if mf.length <= mf.followed {
+		return empty, nil
+	}
+
+	buf := make([]byte, mf.length-mf.followed)
+	if _, err := mf.readAt(buf, mf.followed); err != nil {
+		return empty, err
+	}
+
+	last := bytes.LastIndexByte(buf, '\n')
+	if last == -1 {
+		return empty, nil
+	}
+
+	lr := LineRange{
+		Start: mf.followed,
+		End:   mf.followed + int64(last) + 1,
+		Lines: bytes.Count(buf[:last+1], []byte{'\n'}),
+	}
+	mf.followed = lr.End
+
+	return lr, nil
+}
+
+// Read the lines in the given range, starting with any drained from a
+// rotated file.
+func (mf *MemFile) ReadRange(lr LineRange) ([]string, error) {
+	lines := append([]string{}, lr.drained...)
+
+	if lr.End <= lr.Start {
+		return lines, nil
+	}
+
+	buf, err := mf.Slice(lr.Start, lr.End-lr.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(lines, strings.Split(strings.TrimSuffix(buf, "\n"), "\n")...), nil
+}
+
+// Something that can tell us that a file might have changed.
+type watcher interface {
+	Wake() <-chan struct{}
+	Close() error
+}
+
+// A follower watches a file and signals when it may have grown.
+//
+// Changes are found by polling the file's size and modification time,
+// and on Linux by inotify as well, so that they are seen at once.
+// Consumers call `Refresh` on the source when signalled, so that the
+// file is only re-mapped by its owner.
+type Follower struct {
+	path     string
+	stat     func() (os.FileInfo, error)
+	interval time.Duration
+	changed  chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	watch    watcher
+
+	info os.FileInfo
+}
+
+// Follow the file, checking it at the given interval.
+func (mf *MemFile) Follow(interval time.Duration) (*Follower, error) {
+	if mf.compression != COMPRESS_NONE {
+		return nil, fmt.Errorf("Cannot follow %s compressed files", mf.compression)
+	}
+
+	stat := func() (os.FileInfo, error) {
+		return os.Stat(mf.path)
+	}
+
+	// Polling still works if there is no watcher.
+	watch, err := newWatcher(mf.path)
+	if err != nil {
+		watch = nil
+	}
+
+	return newFollower(mf.path, stat, watch, interval)
+}
+
+// Start following something whose state is returned by `stat`.  When
+// there is a path, it is watched again if a new file appears there.
+func newFollower(path string, stat func() (os.FileInfo, error), watch watcher, interval time.Duration) (*Follower, error) {
+	if interval <= 0 {
+		interval = FOLLOW_INTERVAL
+	}
+
+	info, err := stat()
+	if err != nil {
+		if watch != nil {
+			watch.Close()
+		}
+
+		return nil, err
+	}
+
+	f := &Follower{
+		path:     path,
+		stat:     stat,
+		interval: interval,
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		watch:    watch,
+		info:     info,
+	}
+
+	go f.run()
+
+	return f, nil
+}
+
+// Return a channel that receives a value when the file may have
+// changed.  The channel is closed when the follower is closed, or when
+// what it follows can no longer change.
+func (f *Follower) Changed() <-chan struct{} {
+	return f.changed
+}
+
+func (f *Follower) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+	})
+
+	return nil
+}
+
+func (f *Follower) check() {
+	info, err := f.stat()
+	if err != nil {
+		return
+	}
+
+	if os.SameFile(f.info, info) &&
+		info.Size() == f.info.Size() &&
+		info.ModTime().Equal(f.info.ModTime()) {
+		return
+	}
+
+	// A watch follows the file rather than the path, so a file that
+	// has been rotated needs a new one.
+	if !os.SameFile(f.info, info) && f.path != "" && f.watch != nil {
+		f.watch.Close()
+		f.watch = nil
+
+		if watch, err := newWatcher(f.path); err == nil {
+			f.watch = watch
+		}
+	}
+
+	f.info = info
+	f.notify()
+}
+
+func (f *Follower) notify() {
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (f *Follower) run() {
+	var wake <-chan struct{} = nil
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	defer close(f.changed)
+	defer func() {
+		if f.watch != nil {
+			f.watch.Close()
+		}
+	}()
+
+	if f.watch != nil {
+		wake = f.watch.Wake()
+	}
+
+	for {
+		select {
+		case <-f.done:
+			return
+
+		case <-ticker.C:
+			f.check()
+
+		case _, ok := <-wake:
+			// A watcher closes its channel when nothing more can
+			// change.
+			if !ok {
+				f.notify()
+				return
+			}
+
+			f.check()
+		}
+
+		if f.watch != nil {
+			wake = f.watch.Wake()
+		} else {
+			wake = nil
+		}
+	}
+}
+
+/* follow.go ends here. */
@@ -0,0 +1,435 @@
+/*
+ * lineindex.go --- Sparse line offset index.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// Lines between the offsets kept by a line index.
+	LINE_INDEX_STEP int = 1024
+
+	// Indexes of files smaller than this are not worth keeping.
+	LINE_INDEX_PERSIST_MIN int64 = 16 * 1024 * 1024
+
+	lineIndexVersion int = 1
+	lineIndexChunk   int = 32768
+	lineIndexTail    int = 4096
+)
+
+var (
+	INDEXING error = errors.New("Not indexed yet")
+)
+
+// Return the path of the cached line index for the given file, or an
+// empty string if there is no user cache directory.
+func LineIndexPath(path string) string {
+	return cachePath(path, ".lines")
+}
+
+// A line index is written to the cache like so.
+//
+// The hash of the data before `Size` lets an index be used for a file
+// that has grown since.
+type lineIndexFile struct {
+	Version int     `json:"version"`
+	Step    int     `json:"step"`
+	Size    int64   `json:"size"`
+	Lines   int     `json:"lines"`
+	Tail    string  `json:"tail"`
+	Offsets []int64 `json:"offsets"`
+}
+
+// A sparse index of the offsets at which lines start.
+//
+// The offset of every `LINE_INDEX_STEP`th line is kept, so that finding
+// a line or the line at an offset needs only a short scan.  The index
+// is built in the background from a reader of its own, and is extended
+// when the file grows.
+//
+// While the index is being built, lookups are answered from the part
+// that has been built, and fail with `INDEXING` beyond it.
+type LineIndex struct {
+	sync.Mutex
+
+	rdr    io.ReaderAt
+	closer io.Closer
+	cache  string
+
+	step    int
+	offsets []int64
+	lines   int
+	scanned int64
+	saved   int64
+	err     error
+
+	ready chan struct{}
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// Start indexing the given number of bytes from the reader.  The index
+// is cached under the given path when it is not empty.
+func NewLineIndex(rdr io.ReaderAt, closer io.Closer, length int64, cache string) *LineIndex {
+	li := &LineIndex{
+		rdr:     rdr,
+		closer:  closer,
+		step:    LINE_INDEX_STEP,
+		offsets: []int64{0},
+		ready:   make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	if cache != "" && length >= LINE_INDEX_PERSIST_MIN && IndexCache() {
+		li.cache = cache
+		if li.load(length) {
+			touchCached(cache)
+		}
+	}
+
+	go li.build(length)
+
+	return li
+}
+
+// Build the index, taking the lock a chunk at a time so that lookups
+// can be answered as it is built.
+func (li *LineIndex) build(length int64) {
+	defer close(li.ready)
+
+	buf := make([]byte, lineIndexChunk)
+
+	for {
+		select {
+		case <-li.stop:
+			return
+		default:
+		}
+
+		li.Lock()
+		done, err := li.scanChunk(buf, length)
+		li.err = err
+		li.Unlock()
+
+		if done || err != nil {
+			return
+		}
+	}
+}
+
+// Is the index built?
+func (li *LineIndex) built() bool {
+	select {
+	case <-li.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Return a channel that is closed when the index has been built.
+func (li *LineIndex) Ready() <-chan struct{} {
+	return li.ready
+}
+
+// Extend the built index to the given length.
+func (li *LineIndex) extend(length int64) error {
+	if li.err != nil || !li.built() {
+		return li.err
+	}
+
+	buf := make([]byte, lineIndexChunk)
+	for {
+		done, err := li.scanChunk(buf, length)
+		if err != nil {
+			li.err = err
+		}
+
+		if done || err != nil {
+			return li.err
+		}
+	}
+}
+
+// Count the lines in the next chunk up to the given length, returning
+// whether the length has been reached.
+func (li *LineIndex) scanChunk(buf []byte, length int64) (bool, error) {
+	if li.scanned >= length {
+		return true, nil
+	}
+
+	size := int64(len(buf))
+	if length-li.scanned < size {
+		size = length - li.scanned
+	}
+
+	n, err := li.rdr.ReadAt(buf[:size], li.scanned)
+	if n == 0 && err != nil {
+		return false, err
+	}
+
+	chunk := buf[:n]
+	for {
+		pos := bytes.IndexByte(chunk, '\n')
+		if pos == -1 {
+			break
+		}
+
+		li.lines++
+		if li.lines%li.step == 0 {
+			li.offsets = append(li.offsets, li.scanned+int64(n-len(chunk)+pos+1))
+		}
+
+		chunk = chunk[pos+1:]
+	}
+
+	li.scanned += int64(n)
+
+	return li.scanned >= length, nil
+}
+
+// Return the number of lines in the first `length` bytes, or the number
+// found so far while the index is being built.
+func (li *LineIndex) Lines(length int64) (int, error) {
+	li.Lock()
+	defer li.Unlock()
+
+	if err := li.extend(length); err != nil {
+		return 0, err
+	}
+
+	return li.lines, nil
+}
+
+// Return the offset at which the given line starts, counting from zero.
+func (li *LineIndex) LineOffset(line int, length int64) (int64, error) {
+	li.Lock()
+	defer li.Unlock()
+
+	if err := li.extend(length); err != nil {
+		return 0, err
+	}
+
+	if line > li.lines && !li.built() {
+		return 0, INDEXING
+	}
+
+	if line < 0 || line > li.lines {
+		return 0, fmt.Errorf("Line %d is out of range", line)
+	}
+
+	offset := li.offsets[line/li.step]
+	skip := line % li.step
+	buf := make([]byte, lineIndexChunk)
+
+	for skip > 0 {
+		n, err := li.rdr.ReadAt(buf, offset)
+		if n == 0 && err != nil {
+			return 0, err
+		}
+
+		chunk := buf[:n]
+		for skip > 0 {
+			pos := bytes.IndexByte(chunk, '\n')
+			if pos == -1 {
+				break
+			}
+
+			skip--
+			chunk = chunk[pos+1:]
+		}
+
+		offset += int64(n - len(chunk))
+	}
+
+	return offset, nil
+}
+
+// Return the line, counting from zero, that the given offset is in.
+func (li *LineIndex) OffsetLine(offset int64, length int64) (int, error) {
+	li.Lock()
+	defer li.Unlock()
+
+	if err := li.extend(length); err != nil {
+		return 0, err
+	}
+
+	if offset > li.scanned && !li.built() {
+		return 0, INDEXING
+	}
+
+	if offset < 0 || offset > li.scanned {
+		return 0, fmt.Errorf("Offset %d is out of range", offset)
+	}
+
+	idx := sort.Search(len(li.offsets), func(i int) bool {
+		return li.offsets[i] > offset
+	}) - 1
+
+	line := idx * li.step
+	pos := li.offsets[idx]
+	buf := make([]byte, lineIndexChunk)
+
+	for pos < offset {
+		size := int64(len(buf))
+		if offset-pos < size {
+			size = offset - pos
+		}
+
+		n, err := li.rdr.ReadAt(buf[:size], pos)
+		if n == 0 && err != nil {
+			return 0, err
+		}
+
+		line += bytes.Count(buf[:n], []byte{'\n'})
+		pos += int64(n)
+	}
+
+	return line, nil
+}
+
+// Stop indexing, saving the index if it is cached.
+func (li *LineIndex) Close() error {
+	var err error
+
+	li.once.Do(func() {
+		close(li.stop)
+		<-li.ready
+
+		li.Lock()
+		defer li.Unlock()
+
+		if li.cache != "" && li.err == nil && li.scanned > li.saved {
+			li.save()
+		}
+
+		if li.closer != nil {
+			err = li.closer.Close()
+		}
+	})
+
+	return err
+}
+
+// Hash the data just before the given offset.
+func (li *LineIndex) tail(offset int64) (string, error) {
+	start := offset - int64(lineIndexTail)
+	if start < 0 {
+		start = 0
+	}
+
+	buf := make([]byte, offset-start)
+	if _, err := li.rdr.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	sum := sha1.Sum(buf)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Load a cached index if it is of the start of the file.
+func (li *LineIndex) load(length int64) bool {
+	data, err := os.ReadFile(li.cache)
+	if err != nil {
+		return false
+	}
+
+	saved := &lineIndexFile{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return false
+	}
+
+	if saved.Version != lineIndexVersion ||
+		saved.Step != li.step ||
+		saved.Size > length ||
+		len(saved.Offsets) != saved.Lines/saved.Step+1 {
+		return false
+	}
+
+	if tail, err := li.tail(saved.Size); err != nil || tail != saved.Tail {
+		return false
+	}
+
+	li.offsets = saved.Offsets
+	li.lines = saved.Lines
+	li.scanned = saved.Size
+	li.saved = saved.Size
+
+	return true
+}
+
+// Write the index to the cache, ignoring failure as it can be rebuilt.
+func (li *LineIndex) save() {
+	tail, err := li.tail(li.scanned)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(&lineIndexFile{
+		Version: lineIndexVersion,
+		Step:    li.step,
+		Size:    li.scanned,
+		Lines:   li.lines,
+		Tail:    tail,
+		Offsets: li.offsets,
+	})
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(li.cache), 0o755); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(li.cache), filepath.Base(li.cache)+".*")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil || os.Rename(tmp.Name(), li.cache) != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	li.saved = li.scanned
+	pruneCache(li.cache)
+}
+
+/* lineindex.go ends here. */
@@ -25,21 +25,43 @@ package memfile
 import (
 	"golang.org/x/exp/mmap"
 
-	"bytes"
 	"errors"
 	"io"
+	"os"
+	"runtime/debug"
 	//"log"
 )
 
+const (
+	// Bytes read at a time when scanning for newlines.
+	SCAN_PAGE int64 = 512
+)
+
 var (
-	BOF error = errors.New("BOF")
-	EOF error = errors.New("EOF")
+	BOF   error = errors.New("BOF")
+	EOF   error = errors.New("EOF")
+	FAULT error = errors.New("File was truncated while being read")
 )
 
 type MemFile struct {
-	rdr    *mmap.ReaderAt
+	rdr    backing
+	path   string
 	length int64
 	pos    int64
+
+	// How the file is compressed, if it is.
+	compression string
+
+	// Where lines start.
+	index *LineIndex
+
+	// The mapped file, kept open so that it can be drained after it is
+	// rotated away from its path.
+	file *os.File
+	info os.FileInfo
+
+	// Offset up to which lines have been reported while following.
+	followed int64
 }
 
 func NewMemFile() *MemFile {
@@ -47,24 +69,117 @@ func NewMemFile() *MemFile {
 }
 
 func (mf *MemFile) Open(spec string) error {
-	var err error
+	if err := mf.mapFile(spec); err != nil {
+		return err
+	}
+
+	mf.path = spec
+	mf.followed = mf.length
+	mf.pos = 0
+
+	return mf.startIndex()
+}
+
+// Start indexing the lines of the mapped file.
+func (mf *MemFile) startIndex() error {
+	if cr, ok := mf.rdr.(*compressedReader); ok {
+		mf.index = NewLineIndex(cr.clone(), nil, mf.length, LineIndexPath(mf.path))
+		return nil
+	}
 
-	mf.rdr, err = mmap.Open(spec)
+	// The index reads the file from another goroutine, so it needs a
+	// descriptor that is not closed when the file is re-mapped.
+	file, err := os.Open(mf.path)
 	if err != nil {
 		return err
 	}
 
-	// Find the length.
-	mf.length = int64(mf.rdr.Len())
-	mf.pos = 0
+	mf.index = NewLineIndex(file, file, mf.length, LineIndexPath(mf.path))
+
+	return nil
+}
+
+// Map the file at the given path, replacing any current mapping.
+//
+// Compressed files are read through an index of checkpoints instead,
+// which is built the first time they are opened.
+func (mf *MemFile) mapFile(spec string) error {
+	file, err := os.Open(spec)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	head := make([]byte, 4)
+	n, _ := file.ReadAt(head, 0)
+	compression := DetectCompression(head[:n])
+
+	var rdr backing
+	if compression == COMPRESS_NONE {
+		rdr, err = mmap.Open(spec)
+	} else {
+		rdr, err = openCompressed(file, info, compression)
+	}
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if mf.rdr != nil {
+		mf.unmap()
+	}
+
+	mf.rdr = rdr
+	mf.file = file
+	mf.info = info
+	mf.compression = compression
+	mf.length = int64(rdr.Len())
 
 	return nil
 }
 
 func (mf *MemFile) Close() error {
+	if mf.index != nil {
+		mf.index.Close()
+	}
+
+	return mf.unmap()
+}
+
+func (mf *MemFile) unmap() error {
+	if mf.file != nil {
+		mf.file.Close()
+	}
+
 	return mf.rdr.Close()
 }
 
+// Return how the file is compressed, or an empty string.
+func (mf *MemFile) Compression() string {
+	return mf.compression
+}
+
+// A file is complete, though it may grow.
+func (mf *MemFile) Complete() bool {
+	return true
+}
+
+// Return the path and size of the index a compressed file is read
+// through, or an empty path.
+func (mf *MemFile) Sidecar() (string, int64) {
+	if cr, ok := mf.rdr.(*compressedReader); ok {
+		return cr.Sidecar()
+	}
+
+	return "", 0
+}
+
 func (mf *MemFile) Len() int64 {
 	return mf.length
 }
@@ -73,26 +188,29 @@ func (mf *MemFile) MaxOffset() int64 {
 	return mf.Len() - 1
 }
 
+// Return the number of lines, or the number found so far if the file
+// is still being indexed.
 func (mf *MemFile) Lines() (int, error) {
-	size := int64(32768)
-	buf := make([]byte, size)
-	offset := int64(0)
-	count := 0
-	lineSep := []byte{'\n'}
+	return mf.index.Lines(mf.length)
+}
 
-	for {
-		c, err := mf.rdr.ReadAt(buf, offset)
-		count += bytes.Count(buf[:c], lineSep)
-		offset += size
+// Return a channel that is closed once the file has been indexed.
+func (mf *MemFile) Indexed() <-chan struct{} {
+	return mf.index.Ready()
+}
 
-		switch {
-		case err == io.EOF:
-			return count, nil
+// Return the offset at which the given line starts, counting from zero.
+func (mf *MemFile) LineOffset(line int) (int64, error) {
+	return mf.index.LineOffset(line, mf.length)
+}
 
-		case err != nil:
-			return count, err
-		}
-	}
+// Return the line, counting from zero, that the given offset is in.
+func (mf *MemFile) OffsetLine(offset int64) (int, error) {
+	return mf.index.OffsetLine(offset, mf.length)
+}
+
+func (mf *MemFile) GotoStart() {
+	mf.pos = 0
 }
 
 func (mf *MemFile) GotoEnd() {
@@ -100,15 +218,17 @@ func (mf *MemFile) GotoEnd() {
 }
 
 // Scan from the origin towards BOF looking for a newline.
-func (mf *MemFile) PrevNewLine(origin int64) (int64, int64, int64) {
+func (mf *MemFile) PrevNewLine(origin int64) (int64, int64, int64, error) {
 	var max int64 = mf.MaxOffset()
 	var pos int64 = origin
 	var end int64 = max
 	var start int64 = origin
 	var ch byte
+	var err error
+	var sc scanner = scanner{mf: mf}
 
 	if pos <= 0 {
-		return 0, 0, 0
+		return 0, 0, 0, nil
 	}
 
 	if pos >= max {
@@ -122,7 +242,9 @@ func (mf *MemFile) PrevNewLine(origin int64) (int64, int64, int64) {
 			break
 		}
 
-		ch = mf.rdr.At(int(pos))
+		if ch, err = sc.byteAt(pos); err != nil {
+			return 0, 0, 0, err
+		}
 		//log.Printf("      pos:%d  ch:'%c'\n", pos, ch)
 		if ch == '\n' {
 			if pos == origin {
@@ -139,19 +261,21 @@ func (mf *MemFile) PrevNewLine(origin int64) (int64, int64, int64) {
 	}
 
 	//log.Printf("END   pos:%d  start:%d  end:%d", pos, start, end)
-	return pos, start, end
+	return pos, start, end, nil
 }
 
 // Scan from the origin towards EOF looking for a newline.
-func (mf *MemFile) NextNewLine(origin int64) (int64, int64, int64) {
+func (mf *MemFile) NextNewLine(origin int64) (int64, int64, int64, error) {
 	var max int64 = mf.MaxOffset()
 	var pos int64 = origin
 	var end int64 = origin
 	var start int64
 	var ch byte
+	var err error
+	var sc scanner = scanner{mf: mf}
 
 	if pos >= max {
-		return 0, 0, 0
+		return 0, 0, 0, nil
 	}
 
 	//log.Printf("START pos:%d\n", pos)
@@ -161,7 +285,9 @@ func (mf *MemFile) NextNewLine(origin int64) (int64, int64, int64) {
 			break
 		}
 
-		ch = mf.rdr.At(int(pos))
+		if ch, err = sc.byteAt(pos); err != nil {
+			return 0, 0, 0, err
+		}
 		//log.Printf("      pos:%d  ch:'%c'\n", pos, ch)
 		if ch == '\n' {
 			if pos == origin {
@@ -178,13 +304,73 @@ func (mf *MemFile) NextNewLine(origin int64) (int64, int64, int64) {
 	}
 
 	//log.Printf("END   pos:%d  start:%d  end:%d", pos, start, end)
-	return pos, start, end
+	return pos, start, end, nil
+}
+
+// Read from the mapping.
+//
+// Reading pages of a mapped file that has since been truncated faults
+// rather than failing, so the fault is turned into an error.
+func (mf *MemFile) readAt(buf []byte, offset int64) (n int, err error) {
+	saved := debug.SetPanicOnFault(true)
+	defer func() {
+		debug.SetPanicOnFault(saved)
+
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+
+			n, err = 0, FAULT
+		}
+	}()
+
+	return mf.rdr.ReadAt(buf, offset)
+}
+
+// Reads the bytes looked at by a scan a page at a time through
+// `readAt`, so that a fault is returned as an error.
+type scanner struct {
+	mf   *MemFile
+	page []byte
+	at   int64
+}
+
+func (sc *scanner) byteAt(pos int64) (byte, error) {
+	if pos >= sc.at && pos < sc.at+int64(len(sc.page)) {
+		return sc.page[pos-sc.at], nil
+	}
+
+	start := pos - pos%SCAN_PAGE
+	size := SCAN_PAGE
+	if start+size > sc.mf.length {
+		size = sc.mf.length - start
+	}
+
+	if sc.page == nil {
+		sc.page = make([]byte, SCAN_PAGE)
+	}
+
+	n, err := sc.mf.readAt(sc.page[:size], start)
+	if int64(n) <= pos-start {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = EOF
+		}
+
+		return 0, err
+	}
+
+	sc.page = sc.page[:n]
+	sc.at = start
+
+	return sc.page[pos-start], nil
 }
 
-func (mf *MemFile) doRead(offset, size int64) (string, error) {
+// Read the given number of bytes from the offset.
+func (mf *MemFile) Slice(offset, size int64) (string, error) {
 	var buf []byte = make([]byte, size)
 
-	bread, err := mf.rdr.ReadAt(buf, offset)
+	bread, err := mf.readAt(buf, offset)
 	if err != nil {
 		return "", err
 	}
@@ -210,13 +396,22 @@ func (mf *MemFile) ReadPrevLine() (string, error) {
 		nl := mf.PrevNewLine(mf.pos)
 		size := mf.pos - nl
 	*/
-	pos, start, end := mf.PrevNewLine(mf.pos)
+	pos, start, end, err := mf.PrevNewLine(mf.pos)
+	if err != nil {
+		return "", err
+	}
+
 	size := end - start
 	mf.pos = pos
 
 	//log.Printf("pos:%d  start:%d  size:%d\n", mf.pos, start, size)
 
-	return mf.doRead(start, size)
+	// Blank lines have nothing to read.
+	if size == 0 {
+		return "", nil
+	}
+
+	return mf.Slice(start, size)
 }
 
 // Read the next line, moving towards EOL.
@@ -224,7 +419,7 @@ func (mf *MemFile) ReadNextLine() (string, error) {
 	//log.Printf("AT    pos:%d  max:%d\n", mf.pos, mf.MaxOffset())
 
 	// If we're at the EOF, then signal it via error.
-	if mf.pos == mf.MaxOffset() {
+	if mf.pos >= mf.MaxOffset() {
 		return "", EOF
 	}
 
@@ -234,10 +429,20 @@ func (mf *MemFile) ReadNextLine() (string, error) {
 		size := mf.pos + nl
 	*/
 
-	pos, start, end := mf.NextNewLine(mf.pos)
+	pos, start, end, err := mf.NextNewLine(mf.pos)
+	if err != nil {
+		return "", err
+	}
+
 	size := end - start
-	buf, err := mf.doRead(start, size)
 	mf.pos = pos
+
+	// Blank lines have nothing to read.
+	if size == 0 {
+		return "", nil
+	}
+
+	buf, err := mf.Slice(start, size)
 	//log.Printf("'%s'\n", buf)
 
 	/*
@@ -251,6 +456,34 @@ func (mf *MemFile) ReadNextLine() (string, error) {
 	return buf, err
 }
 
+// Read up to the given number of lines from the start of the file.
+//
+// The current position is not affected.
+func (mf *MemFile) Head(lines int) ([]string, error) {
+	var result []string = []string{}
+
+	saved := mf.pos
+	defer func() {
+		mf.pos = saved
+	}()
+
+	mf.pos = 0
+	for len(result) < lines {
+		buf, err := mf.ReadNextLine()
+		if err != nil {
+			if errors.Is(err, EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		result = append(result, buf)
+	}
+
+	return result, nil
+}
+
 func (mf *MemFile) MakeWindow(lines int) *Window {
 	wnd := &Window{
 		file:  mf,
@@ -0,0 +1,92 @@
+/*
+ * memfile_test.go --- Memory-mapped file tests.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTemp(t *testing.T, data string) *MemFile {
+	path := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mf := NewMemFile()
+	if err := mf.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mf.Close() })
+
+	return mf
+}
+
+func TestHeadEmpty(t *testing.T) {
+	mf := openTemp(t, "")
+
+	lines, err := mf.Head(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 0 {
+		t.Errorf("Expected no lines, got %q", lines)
+	}
+}
+
+func TestHeadBlankLines(t *testing.T) {
+	mf := openTemp(t, "one\n\ntwo\n")
+
+	lines, err := mf.Head(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"one", "", "two"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Expected %q, got %q", want, lines)
+	}
+}
+
+func TestReadPrevLineBlankLines(t *testing.T) {
+	mf := openTemp(t, "one\n\ntwo\n")
+	mf.GotoEnd()
+
+	lines := []string{}
+	for {
+		line, err := mf.ReadPrevLine()
+		if err != nil {
+			break
+		}
+
+		lines = append(lines, line)
+	}
+
+	if want := []string{"two", "", "one"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Expected %q, got %q", want, lines)
+	}
+}
+
+/* memfile_test.go ends here. */
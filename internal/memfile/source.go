@@ -0,0 +1,98 @@
+/*
+ * source.go --- Sources of lines.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// File name that stands for the standard input.
+	STDIN string = "-"
+)
+
+// Something lines can be read from, forwards and backwards.
+//
+// Offsets are in bytes.  The length of a source still being read is
+// the length of what has been read so far.
+type LineSource interface {
+	// Length of the source, and whether all of it has been read.
+	Len() int64
+	MaxOffset() int64
+	Complete() bool
+
+	Lines() (int, error)
+	Indexed() <-chan struct{}
+	LineOffset(int) (int64, error)
+	OffsetLine(int64) (int, error)
+
+	PrevNewLine(int64) (int64, int64, int64, error)
+	NextNewLine(int64) (int64, int64, int64, error)
+	Slice(int64, int64) (string, error)
+
+	GotoStart()
+	GotoEnd()
+	ReadPrevLine() (string, error)
+	ReadNextLine() (string, error)
+	Head(int) ([]string, error)
+	MakeWindow(int) *Window
+
+	Refresh() (LineRange, error)
+	ReadRange(LineRange) ([]string, error)
+	Follow(time.Duration) (*Follower, error)
+
+	Close() error
+}
+
+// Is the file a pipe or a redirection rather than a terminal?
+func Piped(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// Open the named file, or spool the standard input when the name is
+// empty or `STDIN`.
+func OpenSource(spec string) (LineSource, error) {
+	if spec == "" || spec == STDIN {
+		spool := NewSpool()
+		if err := spool.Open(os.Stdin); err != nil {
+			return nil, err
+		}
+
+		return spool, nil
+	}
+
+	mf := NewMemFile()
+	if err := mf.Open(spec); err != nil {
+		return nil, err
+	}
+
+	return mf, nil
+}
+
+/* source.go ends here. */
@@ -0,0 +1,301 @@
+/*
+ * spool.go --- Lines spooled from a stream.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Size of the reads made from a spooled stream.
+	SPOOL_PAGE int = 65536
+
+	// How long to wait for the start of a stream that is followed.
+	SPOOL_WAIT time.Duration = time.Second
+)
+
+// ==================================================================
+// {{{ Reading:
+
+// Reads the spool, which cannot be mapped as it has no name.
+type spoolReader struct {
+	file   *os.File
+	length int64
+}
+
+func (sr *spoolReader) Len() int {
+	return int(sr.length)
+}
+
+func (sr *spoolReader) Close() error {
+	return sr.file.Close()
+}
+
+// Read from the spool, which may hold more than has been taken into it.
+func (sr *spoolReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset >= sr.length {
+		return 0, io.EOF
+	}
+
+	if int64(len(buf)) > sr.length-offset {
+		n, err := sr.file.ReadAt(buf[:sr.length-offset], offset)
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return sr.file.ReadAt(buf, offset)
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ Spool:
+
+// Lines read from a stream such as the standard input.
+//
+// The stream is copied into a temporary file with no name as it is
+// read, so that it can be read backwards and windowed like a file.
+// Lines become visible when `Wait`, `WaitLines` or `Refresh` is called.
+type Spool struct {
+	MemFile
+
+	sync.Mutex
+	cond    *sync.Cond
+	spooled int64
+	newline int64
+	lines   int
+	ended   bool
+	err     error
+	wake    chan struct{}
+
+	// The spool could not be unlinked when it was created.
+	remove bool
+}
+
+func NewSpool() *Spool {
+	s := &Spool{
+		wake: make(chan struct{}, 1),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+
+	return s
+}
+
+// Start spooling the stream.
+func (s *Spool) Open(src io.Reader) error {
+	file, err := os.CreateTemp("", "gotools-spool-*")
+	if err != nil {
+		return err
+	}
+
+	// Some systems cannot remove open files.
+	if err := os.Remove(file.Name()); err != nil {
+		s.remove = true
+	}
+
+	s.rdr = &spoolReader{file: file}
+	s.path = STDIN
+	s.index = NewLineIndex(file, nil, 0, "")
+
+	go s.copy(src, file)
+
+	return nil
+}
+
+// Copy the stream into the spool.
+func (s *Spool) copy(src io.Reader, file *os.File) {
+	buf := make([]byte, SPOOL_PAGE)
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := file.Write(buf[:n]); werr != nil {
+				err = werr
+			} else {
+				s.Lock()
+				if last := bytes.LastIndexByte(buf[:n], '\n'); last != -1 {
+					s.newline = s.spooled + int64(last) + 1
+					s.lines += bytes.Count(buf[:n], []byte{'\n'})
+				}
+				s.spooled += int64(n)
+				s.cond.Broadcast()
+				s.Unlock()
+
+				select {
+				case s.wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+
+		if err != nil {
+			s.Lock()
+			if !errors.Is(err, io.EOF) {
+				s.err = err
+			}
+			s.ended = true
+			s.cond.Broadcast()
+			s.Unlock()
+
+			close(s.wake)
+
+			return
+		}
+	}
+}
+
+// Take what has been spooled so far.
+func (s *Spool) grow() error {
+	s.Lock()
+	length, err := s.spooled, s.err
+	s.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if length > s.length {
+		s.length = length
+		s.rdr.(*spoolReader).length = length
+	}
+
+	return nil
+}
+
+// Wait for the whole stream to be spooled.
+func (s *Spool) Wait() error {
+	s.Lock()
+	for !s.ended {
+		s.cond.Wait()
+	}
+	s.Unlock()
+
+	return s.grow()
+}
+
+// Wait for the given number of lines to be spooled, for the stream to
+// end, or for the timeout to expire.
+func (s *Spool) WaitLines(lines int, timeout time.Duration) error {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		s.Lock()
+		defer s.Unlock()
+
+		expired = true
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	s.Lock()
+	for !s.ended && !expired && s.lines < lines {
+		s.cond.Wait()
+	}
+	s.Unlock()
+
+	return s.grow()
+}
+
+// Has the whole stream been spooled?
+func (s *Spool) Complete() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.ended && s.length == s.spooled
+}
+
+// Take what has been spooled, returning the range of complete lines
+// that were added.  Once the stream has ended, a partial last line is
+// included.
+func (s *Spool) Refresh() (LineRange, error) {
+	if err := s.grow(); err != nil {
+		return LineRange{Start: s.followed, End: s.followed}, err
+	}
+
+	lr, err := s.added()
+	if err != nil {
+		return lr, err
+	}
+
+	// Nothing more will be written to the last line.
+	if s.Complete() && s.followed < s.length {
+		lr.End = s.length
+		lr.Lines++
+		s.followed = s.length
+	}
+
+	return lr, nil
+}
+
+// Follow the stream as it is spooled.  The follower is closed when the
+// stream ends.
+func (s *Spool) Follow(interval time.Duration) (*Follower, error) {
+	stat := func() (os.FileInfo, error) {
+		return s.rdr.(*spoolReader).file.Stat()
+	}
+
+	return newFollower("", stat, &spoolWatcher{wake: s.wake}, interval)
+}
+
+func (s *Spool) Close() error {
+	name := s.rdr.(*spoolReader).file.Name()
+	err := s.MemFile.Close()
+
+	if s.remove {
+		os.Remove(name)
+	}
+
+	return err
+}
+
+// }}}
+// ==================================================================
+
+// ==================================================================
+// {{{ Watcher:
+
+// Wakes a follower when the stream is spooled.
+type spoolWatcher struct {
+	wake chan struct{}
+}
+
+func (sw *spoolWatcher) Wake() <-chan struct{} {
+	return sw.wake
+}
+
+func (sw *spoolWatcher) Close() error {
+	return nil
+}
+
+// }}}
+// ==================================================================
+
+/* spool.go ends here. */
@@ -0,0 +1,85 @@
+//go:build linux
+
+/*
+ * watch_linux.go --- Watch files with inotify.
+ *
+ * Copyright (c) 2022 Paul Ward <asmodai@gmail.com>
+ *
+ * Author:     Paul Ward <asmodai@gmail.com>
+ * Maintainer: Paul Ward <asmodai@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public License
+ * as published by the Free Software Foundation; either version 3
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memfile
+
+import (
+	"os"
+	"syscall"
+)
+
+type inotifyWatcher struct {
+	file *os.File
+	wake chan struct{}
+}
+
+// Watch the file for writes using inotify.
+func newWatcher(path string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF)
+	if _, err := syscall.InotifyAddWatch(fd, path, mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// A non-blocking descriptor is read through the runtime poller, so
+	// closing the file stops the reader.
+	w := &inotifyWatcher{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		wake: make(chan struct{}, 1),
+	}
+
+	go w.read()
+
+	return w, nil
+}
+
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 4096)
+
+	for {
+		if _, err := w.file.Read(buf); err != nil {
+			return
+		}
+
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *inotifyWatcher) Wake() <-chan struct{} {
+	return w.wake
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+/* watch_linux.go ends here. */
@@ -50,11 +50,17 @@ func makeBlock(start, end int64) block {
 }
 
 type Window struct {
-	file  *MemFile
+	file  LineSource
 	lines int
 
 	blocks tracker
 	index  int
+
+	// The file has grown since the blocks were made.
+	stale bool
+
+	// Why the blocks could not be made, returned by `Get`.
+	err error
 }
 
 func (w *Window) Lines() int {
@@ -64,8 +70,10 @@ func (w *Window) Lines() int {
 func (w *Window) Setup() {
 	w.blocks = tracker{}
 	w.index = 0
+	w.stale = false
 
-	start, end := w.makeExtents(w.file.MaxOffset())
+	start, end, err := w.makeExtents(w.file.MaxOffset())
+	w.err = err
 
 	w.blocks[w.index] = makeBlock(start, end)
 }
@@ -76,7 +84,12 @@ func (w *Window) MovePrev() bool {
 	}
 
 	if _, ok := w.blocks[w.index+1]; !ok {
-		start, end := w.makeExtents(w.blocks[w.index].Start)
+		start, end, err := w.makeExtents(w.blocks[w.index].Start)
+		if err != nil {
+			w.err = err
+			return false
+		}
+
 		w.blocks[w.index+1] = makeBlock(start, end)
 	}
 	w.index++
@@ -91,33 +104,95 @@ func (w *Window) MoveNext() bool {
 
 	w.index--
 
+	if w.index == 0 && w.stale {
+		w.Setup()
+	}
+
 	return true
 }
 
-func (w *Window) Pct() float64 {
-	lcnt, err := w.file.Lines()
+// Is the window showing the newest lines in the file?
+func (w *Window) Newest() bool {
+	return w.index == 0
+}
+
+// Note that the file has grown.
+//
+// A window showing the newest lines moves to the new end of the file.
+// Otherwise it stays where it is, and moves to the end when it is next
+// moved to the newest lines.
+func (w *Window) Grown() {
+	if w.index == 0 {
+		w.Setup()
+		return
+	}
+
+	w.stale = true
+}
+
+// Return the number of lines above the window and the number of lines
+// in the file.  Until the file has been indexed, the lines above the
+// window may not be known, in which case `ok` is false and the count is
+// of the lines found so far.
+func (w *Window) above() (int, int, bool) {
+	total, err := w.file.Lines()
+	if err != nil {
+		return 0, 0, false
+	}
+
+	// Blocks start at the newline ending the line before them.
+	start := w.blocks[w.index].Start
+	if start <= 0 {
+		return 0, total, true
+	}
+
+	line, err := w.file.OffsetLine(start)
 	if err != nil {
-		panic(err)
+		return 0, total, false
+	}
+
+	return line + 1, total, true
+}
+
+// Return how far through the file the end of the window is.
+func (w *Window) Pct() float64 {
+	above, total, ok := w.above()
+	if ok && total > 0 {
+		return math.Min(100, float64(total-above)/float64(total)*100.0)
 	}
 
-	return math.Min(100, (float64((w.index+1)*w.lines)/float64(lcnt))*100.0)
+	// Go by bytes until the lines are known.
+	size := w.file.Len()
+	if size == 0 {
+		return 100
+	}
+
+	return math.Min(100, float64(size-w.blocks[w.index].Start)/float64(size)*100.0)
 }
 
+// Return the page the window is on and the number of pages, counting
+// from the end of the file.
 func (w *Window) Position() (int, int) {
-	lcnt, err := w.file.Lines()
-	if err != nil {
-		panic(err)
+	page := w.index + 1
+
+	above, total, ok := w.above()
+	if !ok {
+		return page, int(math.Max(float64(page), float64(total/w.lines+1)))
 	}
 
-	return w.index + 1, (lcnt / w.lines) + 1
+	return page, page + (above+w.lines-1)/w.lines
 }
 
 func (w *Window) Get() ([]string, error) {
+	if w.err != nil {
+		return []string{}, w.err
+	}
+
 	if w.blocks[w.index].Size == 0 {
 		return []string{}, EOF
 	}
 
-	buf, err := w.file.doRead(w.blocks[w.index].Start, w.blocks[w.index].Size)
+	buf, err := w.file.Slice(w.blocks[w.index].Start, w.blocks[w.index].Size)
 	if err != nil {
 		return []string{}, err
 	}
@@ -132,14 +207,18 @@ func (w *Window) Get() ([]string, error) {
 	return lines, nil
 }
 
-func (w *Window) makeExtents(origin int64) (int64, int64) {
+func (w *Window) makeExtents(origin int64) (int64, int64, error) {
 	var pos int64 = origin
 	var end int64 = pos
 	var start int64
+	var err error
 
 	for i := 0; i < w.lines; i++ {
 		// Locate previous newline
-		pos, _, _ = w.file.PrevNewLine(pos)
+		pos, _, _, err = w.file.PrevNewLine(pos)
+		if err != nil {
+			return 0, 0, err
+		}
 
 		// If we reach BOF, then we're done.
 		if pos == 0 {
@@ -151,14 +230,17 @@ func (w *Window) makeExtents(origin int64) (int64, int64) {
 	start = pos
 
 	for i := 0; i < w.lines; i++ {
-		pos, _, end = w.file.NextNewLine(pos)
+		pos, _, end, err = w.file.NextNewLine(pos)
+		if err != nil {
+			return 0, 0, err
+		}
 
 		if end == w.file.MaxOffset() {
 			break
 		}
 	}
 
-	return start, end
+	return start, end, nil
 }
 
 /* window.go ends here. */
@@ -131,7 +131,7 @@ func (s *Syntax) MapChildren(fn MapFn) {
 }
 
 func (s *Syntax) compileSearchTerm() (string, string) {
-	parts := strings.Split(s.literal, ":")
+	parts := strings.SplitN(s.literal, ":", 2)
 
 	return parts[0], parts[1]
 }
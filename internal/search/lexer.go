@@ -113,6 +113,20 @@ func (l *Lexer) readRune() (rune, bool) {
 	return r, true
 }
 
+// Field names may be paths such as `http.request.method` or `tags[*]`.
+func isTermRune(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return true
+	}
+
+	switch r {
+	case '_', '-', '.', '[', ']', '*', '@':
+		return true
+	}
+
+	return false
+}
+
 func (l *Lexer) lexTerm() string {
 	var lit string = ""
 
@@ -122,7 +136,7 @@ func (l *Lexer) lexTerm() string {
 			return lit
 		}
 
-		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		if isTermRune(r) {
 			lit += string(r)
 		} else {
 			l.backup()
@@ -214,7 +228,7 @@ func (l *Lexer) Lex() (Position, Token, string) {
 		default:
 			if unicode.IsSpace(r) {
 				continue
-			} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '@' {
 				startPos := l.pos
 				l.backup()
 				lit := l.lexTerm()
@@ -25,10 +25,11 @@ package search
 import (
 	"github.com/Asmodai/gohacks/utils"
 
-	"encoding/json"
+	"github.com/Asmodai/gotools/internal/entity"
 
 	"fmt"
 	"os"
+	"strings"
 )
 
 type VM struct {
@@ -40,7 +41,10 @@ type VM struct {
 	halted bool
 	debug  bool
 
-	buffer map[string]interface{}
+	buffer entity.Line
+	entity entity.Entity
+	schema *entity.Schema
+	format entity.Format
 }
 
 func NewVM() *VM {
@@ -49,6 +53,8 @@ func NewVM() *VM {
 		program: NewProgram(),
 		pc:      0,
 		halted:  true,
+		schema:  entity.DefaultSchema(),
+		format:  entity.DefaultFormat(),
 	}
 }
 
@@ -64,6 +70,70 @@ func (vm *VM) SetDebug(val bool) {
 	vm.debug = val
 }
 
+func (vm *VM) SetSchema(schema *entity.Schema) {
+	vm.schema = schema
+}
+
+func (vm *VM) SetFormat(format entity.Format) {
+	vm.format = format
+}
+
+// Look up the values for a search field in the buffer.
+//
+// Fields are paths into the buffer, such as `http.request.method` or
+// `tags[*]`.  They are looked up verbatim first, then as logical fields
+// of the current schema, so that 'level' will match 'L' in zap
+// development logs.  Callers may be searched by component, such as
+// `caller.file` or `caller.line`.
+func (vm *VM) lookup(field string) []interface{} {
+	if vm.entity != nil {
+		return vm.lookupEntity(field)
+	}
+
+	if vals := vm.buffer.GetAll(field); len(vals) > 0 {
+		return vals
+	}
+
+	if key, ok := vm.schema.Key(field); ok {
+		if vals := vm.buffer.GetAll(key); len(vals) > 0 {
+			return vals
+		}
+	}
+
+	// Components of a caller string, such as `caller.line`.
+	prefix := entity.FIELD_CALLER + "."
+	if strings.HasPrefix(field, prefix) {
+		vals := []interface{}{}
+
+		for _, val := range vm.lookup(entity.FIELD_CALLER) {
+			caller := entity.ParseCallerValue(val)
+			if comp, ok := caller.Component(strings.TrimPrefix(field, prefix)); ok {
+				vals = append(vals, comp)
+			}
+		}
+
+		return vals
+	}
+
+	return []interface{}{}
+}
+
+// Look up a search field in a parsed entity.
+//
+// Keys of the current schema, such as 'msg', are treated as the logical
+// fields they name.
+func (vm *VM) lookupEntity(field string) []interface{} {
+	if name, ok := vm.schema.Field(field); ok {
+		field = name
+	}
+
+	if val, ok := vm.entity.Field(field); ok {
+		return []interface{}{val}
+	}
+
+	return []interface{}{}
+}
+
 func (vm *VM) String() string {
 	return fmt.Sprintf("halted:%-5t  pc:%03d  ac:%03d  ss:%03d  ps:%03d",
 		vm.halted,
@@ -95,14 +165,43 @@ func (vm *VM) SetBuffer(buf string) error {
 		return fmt.Errorf("VM is running!")
 	}
 
-	vm.buffer = map[string]interface{}{}
-	if err := json.Unmarshal([]byte(buf), &vm.buffer); err != nil {
+	line, err := vm.format.Decode(buf)
+	if err != nil {
 		return err
 	}
+	vm.buffer = line
+	vm.entity = nil
+
+	return nil
+}
+
+// Search a parsed entity rather than a line of text.
+func (vm *VM) SetEntity(ent entity.Entity) error {
+	if !vm.halted {
+		return fmt.Errorf("VM is running!")
+	}
+
+	vm.buffer = nil
+	vm.entity = ent
 
 	return nil
 }
 
+// Return the severity of the level in the buffer or entity.
+func (vm *VM) Severity() entity.Severity {
+	if vm.entity != nil {
+		return vm.entity.Severity()
+	}
+
+	for _, val := range vm.lookup(entity.FIELD_LEVEL) {
+		if sev := entity.LevelSeverity(val); sev != entity.SEVERITY_NONE {
+			return sev
+		}
+	}
+
+	return entity.SEVERITY_NONE
+}
+
 func (vm *VM) Result() int {
 	return vm.ac
 }
@@ -213,7 +312,7 @@ func (vm *VM) execute() {
 			{
 				var raw interface{} = vm.program.data[vm.pc].Operand
 				var operand *Term = raw.(*Term)
-				var match [][]byte
+				var vals []interface{}
 
 				if operand.Type() != OPERAND_TERM {
 					vm.Debug("\x1b[33mFIND\x1b[0m: \x1b[31mWRONG TYPE\x1b[0m Result = 0\n")
@@ -221,14 +320,16 @@ func (vm *VM) execute() {
 					goto done_find
 				}
 
-				if _, ok := vm.buffer[operand.Field]; !ok {
+				vals = vm.lookup(operand.Field)
+				if len(vals) == 0 {
 					vm.Debug("\x1b[33mFIND\x1b[0m: \x1b[31mFIELD '%s' NOT FOUND\x1b[0m Result = 0\n", operand.Field)
 					vm.stack.Push(MakeInteger(0))
 					goto done_find
 				}
 
-				match = operand.Compiled.FindAll([]byte(vm.buffer[operand.Field].(string)), -1)
-				if len(match) == 0 {
+				if !utils.Any(vals, func(val interface{}) bool {
+					return operand.Compiled.MatchString(entity.ValueString(val))
+				}) {
 					vm.Debug("\x1b[33mFIND\x1b[0m: \x1b[31mNO MATCH FOR '%s'\x1b[0m Result = 0\n", operand.Pattern)
 					vm.stack.Push(MakeInteger(0))
 					goto done_find
@@ -1,6 +1,6 @@
 #!/bin/bash
 
-levels=("info" "warn" "debug" "fatal")
+levels=("info" "warn" "debug" "error" "dpanic" "panic" "fatal")
 callers=("test/one.go" "test/two.go" "test/three.go")
 msgs=("No coffee"
 "Shit we're out of nuclear fuel"
@@ -491,7 +491,7 @@ makeEntry() {
   lul=${luls[ $RANDOM % ${#luls[@]} ]}
   trace=""
 
-  if [ ${level} == "fatal" ]
+  if [ ${level} == "error" ] || [ ${level} == "dpanic" ] || [ ${level} == "panic" ] || [ ${level} == "fatal" ]
   then
     trace=",\"stacktrace\":\"jebus\n\t/home/vputin/nukes.go:666\ncrap\n\t/home/vputin/threats.go:10\nruntime.main\n\t/usr/lib/go-1.16/src/runtime/proc.go:225\""
   fi